@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -18,21 +19,27 @@ func findhtdocs(t *testing.T) string {
 	return htdocsdir
 }
 
-func launchgohttpd(t *testing.T) *http.Server {
+// launchgohttpd starts Go's web server on a random loopback port and
+// returns it along with its base URL.
+func launchgohttpd(t *testing.T) (*http.Server, string) {
 	htdocs := findhtdocs(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
 	s := &http.Server{
-		Addr:    ":8080",
 		Handler: http.FileServer(http.Dir(htdocs)),
 	}
-	t.Logf("Launching web server on http://localhost:8080/")
-	go s.ListenAndServe()
-	return s
+	url := "http://" + l.Addr().String()
+	t.Logf("Launching web server on %v/", url)
+	go s.Serve(l)
+	return s, url
 }
 
 func TestGet1(t *testing.T) {
-	s := launchgohttpd(t)
+	s, url := launchgohttpd(t)
 
-	resp, err := http.Get("http://localhost:8080/index.html")
+	resp, err := http.Get(url + "/index.html")
 	if err != nil {
 		t.Fatalf("Error issuing request: %v\n", err.Error())
 	}
@@ -46,9 +53,9 @@ func TestGet1(t *testing.T) {
 }
 
 func TestGet2(t *testing.T) {
-	s := launchgohttpd(t)
+	s, url := launchgohttpd(t)
 
-	resp, err := http.Get("http://localhost:8080/cat.html")
+	resp, err := http.Get(url + "/cat.html")
 	if err != nil {
 		t.Fatalf("Error issuing request: %v\n", err.Error())
 	}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"cse224/tritonhttp"
 )
 
+// shutdownTimeout bounds how long a graceful shutdown waits for
+// in-flight requests before dropping their connections.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	currDir, err := os.Getwd()
 	if err != nil {
@@ -22,26 +36,282 @@ func main() {
 	var port = flag.Int("port", 8080, "the localhost port to listen on")
 	var vh_config_path = flag.String("vh_config", default_vh_config_path, "path to the virtual hosting config file")
 	var docroot_dirs_path = flag.String("docroot", default_docroot, "path to the directory that contains all docroot dirs")
+	var tls_port = flag.Int("tls_port", 0, "the localhost port to serve HTTPS on (0 disables HTTPS)")
+	var tls_cert = flag.String("tls_cert", "", "path to the default TLS certificate (PEM)")
+	var tls_key = flag.String("tls_key", "", "path to the private key of the default TLS certificate (PEM)")
+	var redirect_http = flag.Bool("redirect_http", false, "redirect all plain HTTP requests to HTTPS on tls_port")
+	var compress_min_size = flag.Int64("compress_min_size", 1024, "compress text responses of at least this many bytes (negative disables compression)")
+	var admin_port = flag.Int("admin_port", 0, "the localhost port to serve /metrics and /reload on (0 disables the admin listener)")
+	var admin_addr = flag.String("admin_addr", "127.0.0.1", "the address the admin listener binds to; it is unauthenticated, so only widen it, e.g. to 0.0.0.0, behind a firewall")
+	var reload_interval = flag.Duration("reload_interval", 2*time.Second, "how often to check the virtual hosting config file for changes (0 disables polling; SIGHUP always reloads)")
+	var gen_selfsigned = flag.Bool("gen-selfsigned", false, "write a self-signed certificate for all virtual hosts to tls_cert and tls_key, then exit")
+	var check_config = flag.Bool("check-config", false, "validate the virtual hosting config file, report every problem found, then exit")
 	flag.Parse()
 
+	if *check_config {
+		os.Exit(checkConfig(*vh_config_path, *docroot_dirs_path))
+	}
+
+	config, err := tritonhttp.LoadConfig(*vh_config_path, *docroot_dirs_path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// ports from the config file apply unless given on the command line
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if !setFlags["port"] && config.Listen.Port != 0 {
+		*port = config.Listen.Port
+	}
+	if !setFlags["tls_port"] && config.Listen.TLSPort != 0 {
+		*tls_port = config.Listen.TLSPort
+	}
+	if !setFlags["admin_port"] && config.Listen.AdminPort != 0 {
+		*admin_port = config.Listen.AdminPort
+	}
+	if !setFlags["admin_addr"] && config.Listen.AdminAddr != "" {
+		*admin_addr = config.Listen.AdminAddr
+	}
+
 	// Log server configs
 	fmt.Println()
 	log.Print("Server configs:")
 	log.Printf("  port: %v", *port)
 	log.Printf("  path to virtual hosts config file: %v", *vh_config_path)
 	log.Printf("  path to docroot directories: %v", *docroot_dirs_path)
+	if *tls_port != 0 {
+		log.Printf("  tls port: %v", *tls_port)
+	}
+	if *admin_port != 0 {
+		log.Printf("  admin address: %v", net.JoinHostPort(*admin_addr, strconv.Itoa(*admin_port)))
+	}
 	fmt.Println()
 
-	virtualHosts := tritonhttp.ParseVHConfigFile(*vh_config_path, *docroot_dirs_path)
+	virtualHosts := config.VirtualHostMap()
+
+	if *gen_selfsigned {
+		generateSelfSigned(virtualHosts, *tls_cert, *tls_key)
+		return
+	}
 
-	// Start server
+	// Start servers
 	addr := fmt.Sprintf(":%v", *port)
 
 	log.Printf("Starting TritonHTTP server")
 	log.Printf("You can browse the website at http://localhost:%v/", *port)
+	accessLog, err := tritonhttp.LoadAccessLogger(config)
+	if err != nil {
+		log.Fatalf("Could not set up access log: %v", err)
+	}
+	defer accessLog.Close()
+	// The file server, access log settings and TLS certificates are
+	// rebuilt from the config file on every reload; a config that fails
+	// to load changes none of them
+	var certificates atomic.Pointer[tls.Config]
+	reloader, err := tritonhttp.NewReloader(func() (tritonhttp.Handler, error) {
+		reloaded, err := tritonhttp.LoadConfig(*vh_config_path, *docroot_dirs_path)
+		if err != nil {
+			return nil, err
+		}
+		var tlsConfig *tls.Config
+		if *tls_port != 0 {
+			tlsConfig, err = tritonhttp.LoadTLSConfig(reloaded)
+			if err != nil {
+				return nil, err
+			}
+			if tlsConfig == nil && *tls_cert == "" {
+				return nil, errors.New("no TLS certificate configured")
+			}
+		}
+		if err := accessLog.Update(reloaded); err != nil {
+			return nil, fmt.Errorf("access log: %v", err)
+		}
+		certificates.Store(tlsConfig)
+		warnRestartOnly(config, reloaded)
+		return newFileServer(reloaded, *compress_min_size), nil
+	})
+	if err != nil {
+		log.Fatalf("Could not load config: %v", err)
+	}
+	handler := tritonhttp.Chain(reloader, accessLog.Middleware())
+	var metrics *tritonhttp.Metrics
+	if *admin_port != 0 {
+		metrics = tritonhttp.NewMetrics()
+	}
 	s := &tritonhttp.Server{
 		Addr:         addr,
 		VirtualHosts: virtualHosts,
+		MetricsHosts: reloader,
+		Handler:      handler,
+		Metrics:      metrics,
+		AccessLog:    accessLog,
+	}
+	config.ApplyTo(s)
+	servers := []*tritonhttp.Server{s}
+	serveErr := make(chan error, 3)
+
+	if *tls_port != 0 {
+		tlsServer := &tritonhttp.Server{
+			Addr:         fmt.Sprintf(":%v", *tls_port),
+			VirtualHosts: virtualHosts,
+			MetricsHosts: reloader,
+			Handler:      handler,
+			TLSConfig: &tls.Config{
+				// each handshake uses the certificates of the latest
+				// reload; nil falls back to -tls_cert
+				GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					if current := certificates.Load(); current != nil {
+						return current.GetCertificate(hello)
+					}
+					return nil, nil
+				},
+			},
+			Metrics:   metrics,
+			AccessLog: accessLog,
+		}
+		config.ApplyTo(tlsServer)
+		if *redirect_http {
+			s.Handler = tritonhttp.RedirectHTTPS(*tls_port)
+		}
+		servers = append(servers, tlsServer)
+		log.Printf("You can browse the website at https://localhost:%v/", *tls_port)
+		go func() {
+			serveErr <- tlsServer.ListenAndServeTLS(*tls_cert, *tls_key)
+		}()
+	}
+
+	if *admin_port != 0 {
+		adminServer := &tritonhttp.Server{
+			Addr: net.JoinHostPort(*admin_addr, strconv.Itoa(*admin_port)),
+			Handler: tritonhttp.HandlerFunc(func(w tritonhttp.ResponseWriter, r *tritonhttp.Request) {
+				if r.Path == "/reload" {
+					reloader.ServeStatus(w, r)
+					return
+				}
+				metrics.ServeTriton(w, r)
+			}),
+		}
+		servers = append(servers, adminServer)
+		log.Printf("Metrics are served at http://localhost:%v/metrics", *admin_port)
+		log.Printf("Reload status is served at http://localhost:%v/reload", *admin_port)
+		go func() {
+			serveErr <- adminServer.ListenAndServe()
+		}()
+	}
+
+	// Shut down gracefully on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		serveErr <- s.ListenAndServe()
+	}()
+
+	// On SIGHUP, reopen the access logs, as logrotate moved them, and
+	// reload the virtual hosts
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := accessLog.Reopen(); err != nil {
+				log.Printf("Could not reopen access logs: %v", err)
+			}
+			reloader.Reload("SIGHUP")
+		}
+	}()
+	if *reload_interval > 0 {
+		go reloader.Watch(ctx, *vh_config_path, *reload_interval)
+	}
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("Shutting down, waiting up to %v for open requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Graceful shutdown failed: %v", err)
+			srv.Close()
+		}
+	}
+	log.Printf("Server stopped")
+}
+
+// checkConfig validates the config file and the TLS certificates it
+// names, printing every problem found. It returns the exit status.
+func checkConfig(vhConfigPath, docrootDirsPath string) int {
+	config, err := tritonhttp.LoadConfig(vhConfigPath, docrootDirsPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := tritonhttp.LoadTLSConfig(config); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config %s:\n  %v\n", vhConfigPath, err)
+		return 1
+	}
+	fmt.Printf("config %s is valid\n", vhConfigPath)
+	return 0
+}
+
+// newFileServer returns the handler serving the files of the virtual
+// hosts in config.
+func newFileServer(config *tritonhttp.Config, compressMinSize int64) tritonhttp.Handler {
+	fs := &tritonhttp.FileServer{
+		VirtualHosts: config.VirtualHostMap(),
+		HostOptions:  config.HostOptionMap(),
+		Hosts:        config.HostTable(),
+	}
+	if compressMinSize < 0 {
+		return fs
+	}
+	// the middleware hides the file server's Lookup from the reloader
+	return &hostsHandler{
+		Handler:    tritonhttp.Chain(fs, tritonhttp.Compress(compressMinSize)),
+		HostLookup: fs,
+	}
+}
+
+// warnRestartOnly logs when a reloaded config changes settings that
+// the running servers keep until a restart: the ports, timeouts and
+// limits, and allowEncodedSlashes.
+func warnRestartOnly(startup, reloaded *tritonhttp.Config) {
+	if reloaded.Listen != startup.Listen || reloaded.Timeouts != startup.Timeouts ||
+		reloaded.Limits != startup.Limits || reloaded.AllowEncodedSlashes != startup.AllowEncodedSlashes {
+		log.Print("Reloaded config changes listen, timeouts, limits or allowEncodedSlashes, which only apply after a restart")
+	}
+}
+
+// hostsHandler is a Handler that also resolves hosts like the file
+// server it wraps, for the host label of metrics.
+type hostsHandler struct {
+	tritonhttp.Handler
+	tritonhttp.HostLookup
+}
+
+// generateSelfSigned writes a self-signed certificate covering every
+// virtual host, plus localhost, to certPath and keyPath.
+func generateSelfSigned(virtualHosts map[string]string, certPath, keyPath string) {
+	if certPath == "" || keyPath == "" {
+		log.Fatal("-gen-selfsigned requires -tls_cert and -tls_key")
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	for hostName := range virtualHosts {
+		hosts = append(hosts, hostName)
+	}
+	sort.Strings(hosts[3:])
+
+	certPEM, keyPEM, err := tritonhttp.GenerateSelfSigned(hosts)
+	if err != nil {
+		log.Fatalf("Could not generate certificate: %v", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		log.Fatalf("Could not write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		log.Fatalf("Could not write private key: %v", err)
 	}
-	log.Fatal(s.ListenAndServe())
+	log.Printf("Wrote self-signed certificate for %v to %v and %v", hosts, certPath, keyPath)
 }
@@ -4,15 +4,18 @@ import (
 	"bufio"
 	"bytes"
 	"cse224/tritonhttp"
+	"cse224/tritonhttp/tritonhttptest"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -48,27 +51,35 @@ func findhtdocs(t *testing.T) string {
 	return htdocsdir
 }
 
-func launchhttpd(t *testing.T) {
+// launchhttpd starts the selected server on a random loopback port and
+// returns that port. The server is shut down when the test finishes.
+func launchhttpd(t *testing.T) string {
 	switch *usehttpd {
 	case "tritonhttp":
-		launchtritonhttpd(t)
+		return launchtritonhttpd(t)
 	case "go":
-		launchgohttpd(t)
+		return launchgohttpd(t)
 	default:
 		t.Fatalf("Invalid server type %v (must be 'tritonhttp' or 'go')", *usehttpd)
 	}
+	return ""
 }
 
-func launchgohttpd(t *testing.T) {
+func launchgohttpd(t *testing.T) string {
 	htdocs := findhtdocs(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
 	s := &http.Server{
-		Addr:    ":8080",
 		Handler: http.FileServer(http.Dir(htdocs)),
 	}
-	go s.ListenAndServe()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
 }
 
-func launchtritonhttpd(t *testing.T) {
+func launchtritonhttpd(t *testing.T) string {
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
@@ -76,15 +87,13 @@ func launchtritonhttpd(t *testing.T) {
 	log.Println(cwd)
 	t.Log(cwd)
 	virtualHosts := tritonhttp.ParseVHConfigFile("../../virtual_hosts.yaml", "../../docroot_dirs")
-	s := &tritonhttp.Server{
-		Addr:         ":8080",
-		VirtualHosts: virtualHosts,
-	}
-	go s.ListenAndServe()
+	url, cleanup := tritonhttptest.NewServer(virtualHosts)
+	t.Cleanup(cleanup)
+	return url[strings.LastIndex(url, ":")+1:]
 }
 
 func TestGoFetch1(t *testing.T) {
-	launchhttpd(t)
+	port := launchhttpd(t)
 
 	req := fmt.Sprint("GET / HTTP/1.1\r\n"+
 		"Host: website1\r\n",
@@ -92,7 +101,7 @@ func TestGoFetch1(t *testing.T) {
 		"User-Agent: gotest\r\n",
 		"\r\n")
 
-	respbytes, _, err := tritonhttp.Fetch("localhost", "8080", []byte(req))
+	respbytes, _, err := tritonhttp.Fetch("localhost", port, []byte(req))
 	if err != nil {
 		t.Fatalf("Error fetching request: %v\n", err.Error())
 	}
@@ -117,7 +126,7 @@ func TestGoFetch1(t *testing.T) {
 }
 
 func TestGoFetch2(t *testing.T) {
-	launchhttpd(t)
+	port := launchhttpd(t)
 
 	req := fmt.Sprint("GET / HTTP/1.1\r\n",
 		"Host: website1\r\n",
@@ -130,7 +139,7 @@ func TestGoFetch2(t *testing.T) {
 		"\r\n",
 	)
 
-	respbytes, _, err := tritonhttp.Fetch("localhost", "8080", []byte(req))
+	respbytes, _, err := tritonhttp.Fetch("localhost", port, []byte(req))
 	if err != nil {
 		t.Fatalf("Error fetching request: %v\n", err.Error())
 	}
@@ -184,7 +193,7 @@ func TestGoFetch2(t *testing.T) {
 }
 
 func TestGoFetch3(t *testing.T) {
-	launchhttpd(t)
+	port := launchhttpd(t)
 
 	req := fmt.Sprint("foobar\r\n"+
 		"Host: website1\r\n",
@@ -192,7 +201,7 @@ func TestGoFetch3(t *testing.T) {
 		"User-Agent: gotest\r\n",
 		"\r\n")
 
-	respbytes, _, err := tritonhttp.Fetch("localhost", "8080", []byte(req))
+	respbytes, _, err := tritonhttp.Fetch("localhost", port, []byte(req))
 	if err != nil {
 		t.Fatalf("Error fetching request: %v\n", err.Error())
 	}
@@ -212,7 +221,7 @@ func TestGoFetch3(t *testing.T) {
 }
 
 func TestAllFilesInHtdocs(t *testing.T) {
-	launchhttpd(t)
+	port := launchhttpd(t)
 
 	virtualHosts := tritonhttp.ParseVHConfigFile("../../virtual_hosts.yaml", "../../docroot_dirs")
 
@@ -242,7 +251,7 @@ func TestAllFilesInHtdocs(t *testing.T) {
 					"User-Agent: gotest\r\n"+
 					"\r\n", testfile)
 
-				respbytes, _, err := tritonhttp.Fetch("localhost", "8080", []byte(req))
+				respbytes, _, err := tritonhttp.Fetch("localhost", port, []byte(req))
 				if err != nil {
 					t.Fatalf("Error fetching request: %v\n", err.Error())
 				}
@@ -307,7 +316,7 @@ func TestAllFilesInHtdocs(t *testing.T) {
 }
 
 func TestNotFound(t *testing.T) {
-	launchhttpd(t)
+	port := launchhttpd(t)
 
 	req := fmt.Sprintf("GET /hello.txt HTTP/1.1\r\n" +
 		"Host: website1\r\n" +
@@ -315,7 +324,7 @@ func TestNotFound(t *testing.T) {
 		"User-Agent: gotest\r\n" +
 		"\r\n")
 
-	respbytes, _, err := tritonhttp.Fetch("localhost", "8080", []byte(req))
+	respbytes, _, err := tritonhttp.Fetch("localhost", port, []byte(req))
 	if err != nil {
 		t.Fatalf("Error fetching request: %v\n", err.Error())
 	}
@@ -334,3 +343,16 @@ func TestNotFound(t *testing.T) {
 		t.Fatalf("Expected response code of 400 but got: %v\n", resp.StatusCode)
 	}
 }
+
+func TestCheckConfig(t *testing.T) {
+	if status := checkConfig("../../virtual_hosts.yaml", "../../docroot_dirs"); status != 0 {
+		t.Fatalf("exit status for the shipped config got: %v, want: 0", status)
+	}
+	badConfig := filepath.Join(t.TempDir(), "virtual_hosts.yaml")
+	if err := os.WriteFile(badConfig, []byte("virtual_hosts:\n  - hostName: \"a\"\n    docRoot: \"missing\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := checkConfig(badConfig, "../../docroot_dirs"); status != 1 {
+		t.Fatalf("exit status for an invalid config got: %v, want: 1", status)
+	}
+}
@@ -0,0 +1,282 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Access log formats understood by AccessLogger.
+const (
+	// LogCommon is the Common Log Format:
+	//   127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.1" 200 2326
+	LogCommon = "common"
+
+	// LogCombined is the Common Log Format followed by the quoted
+	// Referer and User-Agent headers.
+	LogCombined = "combined"
+
+	// LogJSON writes one JSON object per line with every recorded
+	// field, including the virtual host and the latency.
+	LogJSON = "json"
+)
+
+// clfTimeFormat is the time layout of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogger writes one line per request to the log of the request's
+// virtual host. Files are opened on first use and kept open until
+// Reopen or Close, so that a rotated log can be picked up on SIGHUP.
+type AccessLogger struct {
+	// Format is one of LogCommon, LogCombined or LogJSON. If empty,
+	// LogCommon is used.
+	Format string
+
+	// Output receives the lines of hosts without a file of their own.
+	// If nil, those requests are not logged.
+	Output io.Writer
+
+	// File, if set, is the path of the log file for hosts without one
+	// of their own, used instead of Output.
+	File string
+
+	// HostFiles maps host names to the paths of their log files.
+	HostFiles map[string]string
+
+	// Hosts optionally resolves the Host header of requests to host
+	// names, so that aliases and wildcard matches are logged as, and
+	// to the file of, their virtual host.
+	Hosts *HostTable
+
+	mu    sync.Mutex
+	files map[string]*os.File // open log files, keyed by path
+}
+
+// accessRecord holds what is logged about a single request.
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	User       string    `json:"user,omitempty"`
+	Host       string    `json:"host"`
+	Request    string    `json:"request"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	LatencyMs  float64   `json:"latencyMs"`
+}
+
+// Middleware returns a Middleware that logs every request served by
+// the wrapped Handler. Requests the Server answers itself, because
+// they cannot be read or there are too many connections, never reach
+// it; set Server.AccessLog to log those too.
+func (l *AccessLogger) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			start := time.Now()
+			sr := &statusRecorder{ResponseWriter: w}
+			next.ServeTriton(sr, r)
+			if sr.status == 0 {
+				sr.status = 200
+			}
+			l.log(l.newRecord(start, r, sr.status, sr.written))
+		})
+	}
+}
+
+// logRejected logs a request the Server answered with status without
+// handing it to a Handler. req holds what could be read of the
+// request, and may be nil.
+func (l *AccessLogger) logRejected(start time.Time, remoteAddr string, req *Request, status int, size int64) {
+	if req == nil {
+		req = &Request{}
+	}
+	req.RemoteAddr = remoteAddr
+	l.log(l.newRecord(start, req, status, size))
+}
+
+// newRecord returns the record of r, which was answered with status
+// and size bytes of body after starting at start.
+func (l *AccessLogger) newRecord(start time.Time, r *Request, status int, size int64) *accessRecord {
+	user, _, _ := basicAuth(r)
+	// a request whose request line could not be read is logged as "-"
+	request := "-"
+	if r.Method != "" {
+		request = r.Method + " " + r.URL + " " + r.Proto
+	}
+	return &accessRecord{
+		Time:       start,
+		RemoteAddr: remoteHost(r.RemoteAddr),
+		User:       user,
+		Host:       r.Host,
+		Request:    request,
+		Status:     status,
+		Bytes:      size,
+		Referer:    r.Headers["Referer"],
+		UserAgent:  r.Headers["User-Agent"],
+		LatencyMs:  float64(time.Since(start).Microseconds()) / 1000,
+	}
+}
+
+// log writes rec to the log of its host, resolving the host as
+// l.Hosts does. The settings are read under l.mu, as Update may change
+// them at any time.
+func (l *AccessLogger) log(rec *accessRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Hosts != nil {
+		if name := l.Hosts.Lookup(rec.Host); name != "" {
+			rec.Host = name
+		}
+	}
+	w, err := l.writerFor(rec.Host)
+	if err != nil {
+		log.Println("access log error: ", err)
+		return
+	}
+	if w != nil {
+		w.Write(l.formatRecord(rec))
+	}
+}
+
+// writerFor returns where the lines of host go, opening its log file if
+// needed. l.mu must be held.
+func (l *AccessLogger) writerFor(host string) (io.Writer, error) {
+	path, ok := l.HostFiles[host]
+	if !ok {
+		if l.File == "" {
+			return l.Output, nil
+		}
+		path = l.File
+	}
+	if file, ok := l.files[path]; ok {
+		return file, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if l.files == nil {
+		l.files = make(map[string]*os.File)
+	}
+	l.files[path] = file
+	return file, nil
+}
+
+func (l *AccessLogger) formatRecord(rec *accessRecord) []byte {
+	if l.Format == LogJSON {
+		line, _ := json.Marshal(rec)
+		return append(line, '\n')
+	}
+	bytes := "-"
+	if rec.Bytes > 0 {
+		bytes = strconv.FormatInt(rec.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		orDash(rec.RemoteAddr), orDash(rec.User), rec.Time.Format(clfTimeFormat),
+		strconv.Quote(rec.Request), rec.Status, bytes)
+	if l.Format == LogCombined {
+		line += " " + strconv.Quote(orDash(rec.Referer)) + " " + strconv.Quote(orDash(rec.UserAgent))
+	}
+	return []byte(line + "\n")
+}
+
+// Reopen closes the open log files; each is opened again, under its
+// configured path, by the next request that logs to it. Call it once
+// logrotate has moved the files away.
+func (l *AccessLogger) Reopen() error {
+	return l.Close()
+}
+
+// Close closes the open log files.
+func (l *AccessLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeFiles()
+}
+
+// Update switches l to the format, files and hosts that
+// LoadAccessLogger would set up for config, keeping Output. Open log
+// files are closed, so lines go to the new paths from the next
+// request on. If a log file of config cannot be opened, l is left
+// as it was.
+func (l *AccessLogger) Update(config *Config) error {
+	updated, err := LoadAccessLogger(config)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Format = updated.Format
+	l.File = updated.File
+	l.HostFiles = updated.HostFiles
+	l.Hosts = updated.Hosts
+	return l.closeFiles()
+}
+
+// closeFiles closes the open log files. l.mu must be held.
+func (l *AccessLogger) closeFiles() error {
+	var firstErr error
+	for path, file := range l.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(l.files, path)
+	}
+	return firstErr
+}
+
+// LoadAccessLogger sets up logging as a config returned by LoadConfig
+// says: the top-level accessLogFormat, the top-level accessLog file for
+// hosts without their own, and each host's accessLog file. Relative
+// paths are resolved against the directory of the config file. Without
+// a top-level accessLog, those hosts log to stdout.
+func LoadAccessLogger(config *Config) (*AccessLogger, error) {
+	l := &AccessLogger{
+		Format:    config.AccessLogFormat,
+		Output:    os.Stdout,
+		HostFiles: make(map[string]string),
+		Hosts:     config.HostTable(),
+	}
+	paths := []string{}
+	if config.AccessLog != "" {
+		l.File = config.resolvePath(config.AccessLog)
+		paths = append(paths, l.File)
+	}
+	for _, vhost := range config.VirtualHosts {
+		if vhost.AccessLog != "" {
+			l.HostFiles[vhost.HostName] = config.resolvePath(vhost.AccessLog)
+			paths = append(paths, l.HostFiles[vhost.HostName])
+		}
+	}
+	// fail now rather than on the first request if a log is unwritable
+	for _, path := range paths {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+	}
+	return l, nil
+}
+
+// remoteHost strips the port from a "host:port" address.
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	rec := &accessRecord{
+		Time:       time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		RemoteAddr: "127.0.0.1",
+		User:       "frank",
+		Host:       "website1",
+		Request:    "GET /a.gif HTTP/1.1",
+		Status:     200,
+		Bytes:      2326,
+		Referer:    "http://example.test/start.html",
+		UserAgent:  "Mozilla/4.08",
+		LatencyMs:  1.5,
+	}
+	var tests = []struct {
+		format string
+		want   string
+	}{
+		{LogCommon, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.1" 200 2326` + "\n"},
+		{"", `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.1" 200 2326` + "\n"},
+		{LogCombined, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.1" 200 2326 "http://example.test/start.html" "Mozilla/4.08"` + "\n"},
+	}
+	for _, tt := range tests {
+		l := &AccessLogger{Format: tt.format}
+		if got := string(l.formatRecord(rec)); got != tt.want {
+			t.Fatalf("format %q\ngot:  %q\nwant: %q", tt.format, got, tt.want)
+		}
+	}
+
+	l := &AccessLogger{Format: LogJSON}
+	var got accessRecord
+	if err := json.Unmarshal(l.formatRecord(rec), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Host != "website1" || got.Status != 200 || got.LatencyMs != 1.5 || got.UserAgent != "Mozilla/4.08" {
+		t.Fatalf("json record got: %+v", got)
+	}
+
+	empty := &accessRecord{Time: rec.Time, Request: "GET / HTTP/1.1", Status: 304}
+	l = &AccessLogger{Format: LogCombined}
+	want := `- - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 304 - "-" "-"` + "\n"
+	if got := string(l.formatRecord(empty)); got != want {
+		t.Fatalf("empty fields\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestAccessLogFiles(t *testing.T) {
+	docRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docRoot, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logDir := t.TempDir()
+	configPath := filepath.Join(logDir, "virtual_hosts.yaml")
+	config := "accessLogFormat: combined\n" +
+		"virtual_hosts:\n" +
+		"  - hostName: \"logged\"\n" +
+		"    docRoot: \"" + filepath.Base(docRoot) + "\"\n" +
+		"    accessLog: \"logged.log\"\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vhConfig, err := LoadConfig(configPath, filepath.Dir(docRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadAccessLogger(vhConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	outputPath := filepath.Join(logDir, "output.log")
+	output, err := os.Create(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	l.Output = output
+
+	s := &Server{
+		Handler: Chain(
+			&FileServer{VirtualHosts: map[string]string{"logged": docRoot, "other": docRoot}},
+			l.Middleware(),
+		),
+		AccessLog: l,
+	}
+	logPath := filepath.Join(logDir, "logged.log")
+	waitForLog := func(path, want string) string {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			content, _ := os.ReadFile(path)
+			if strings.Contains(string(content), want) {
+				return string(content)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s does not contain %q", filepath.Base(path), want)
+		return ""
+	}
+
+	serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: logged\r\nUser-Agent: test-agent\r\nConnection: close\r\n\r\n")
+	content := waitForLog(logPath, `"GET /index.html HTTP/1.1" 200 5 "-" "test-agent"`)
+	if strings.Count(content, "\n") != 1 {
+		t.Fatalf("log file got: %q, want one line", content)
+	}
+
+	// after logrotate moves the file away, Reopen starts a new one
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	serveOne(t, s, "GET /missing.html HTTP/1.1\r\nHost: logged\r\nConnection: close\r\n\r\n")
+	content = waitForLog(logPath, `"GET /missing.html HTTP/1.1" 404`)
+	if strings.Contains(content, "index.html") {
+		t.Fatalf("reopened log file still has old lines: %q", content)
+	}
+
+	// hosts without a log file of their own go to Output
+	serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: other\r\nConnection: close\r\n\r\n")
+	waitForLog(outputPath, `"GET /index.html HTTP/1.1" 200 5`)
+
+	// requests the Server refuses before the handler runs are logged
+	// with as much of them as was read
+	serveOne(t, s, "POST /index.html HTTP/1.1\r\nHost: logged\r\nUser-Agent: prober\r\nExpect: 200-ok\r\n\r\n")
+	content = waitForLog(logPath, `"POST /index.html HTTP/1.1" 417 `)
+	if !strings.HasSuffix(content, `"-" "prober"`+"\n") {
+		t.Fatalf("log file got: %q, want the User-Agent of the refused request", content)
+	}
+	serveOne(t, s, "BREW /pot HTTP/1.1\r\n\r\n")
+	waitForLog(outputPath, `"BREW /pot HTTP/1.1" 501`)
+	serveOne(t, s, "garbage\r\n\r\n")
+	waitForLog(outputPath, `"-" 400`)
+}
+
+func TestAccessLogUpdate(t *testing.T) {
+	docRoot := t.TempDir()
+	logDir := t.TempDir()
+	configPath := filepath.Join(logDir, "virtual_hosts.yaml")
+	loadConfig := func(config string) *Config {
+		t.Helper()
+		config = "virtual_hosts:\n" +
+			"  - hostName: \"logged\"\n" +
+			"    docRoot: \"" + filepath.Base(docRoot) + "\"\n" + config
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		vhConfig, err := LoadConfig(configPath, filepath.Dir(docRoot))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vhConfig
+	}
+	readLog := func(name string) string {
+		content, _ := os.ReadFile(filepath.Join(logDir, name))
+		return string(content)
+	}
+	logRequest := func(l *AccessLogger, host string) {
+		l.log(l.newRecord(time.Now(), &Request{Method: "GET", URL: "/", Proto: "HTTP/1.1", Host: host}, 200, 0))
+	}
+
+	l, err := LoadAccessLogger(loadConfig("    accessLog: \"first.log\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	logRequest(l, "logged")
+	if !strings.Contains(readLog("first.log"), `"GET / HTTP/1.1" 200`) {
+		t.Fatalf("first.log got: %q", readLog("first.log"))
+	}
+
+	// the new file, format and aliases apply from the next request on
+	err = l.Update(loadConfig("    aliases: [\"alias\"]\n" +
+		"    accessLog: \"second.log\"\n" +
+		"accessLogFormat: json\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logRequest(l, "alias")
+	var rec accessRecord
+	if err := json.Unmarshal([]byte(readLog("second.log")), &rec); err != nil {
+		t.Fatalf("second.log got: %q, want a JSON line: %v", readLog("second.log"), err)
+	}
+	if rec.Host != "logged" {
+		t.Fatalf("host got: %q, want: %q", rec.Host, "logged")
+	}
+	if strings.Count(readLog("first.log"), "\n") != 1 {
+		t.Fatalf("first.log got: %q, want no new lines", readLog("first.log"))
+	}
+
+	// a config whose log cannot be opened leaves the logger as it was
+	if err := l.Update(loadConfig("    accessLog: \"missing/third.log\"\n")); err == nil {
+		t.Fatal("Update with an unwritable log file succeeded")
+	}
+	logRequest(l, "alias")
+	if strings.Count(readLog("second.log"), "\n") != 2 {
+		t.Fatalf("second.log got: %q, want two lines", readLog("second.log"))
+	}
+}
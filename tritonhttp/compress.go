@@ -0,0 +1,202 @@
+package tritonhttp
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// compressibleTypes lists the media types worth compressing on the fly,
+// besides text/*. Formats that are already compressed, such as
+// image/jpeg and image/png, are deliberately absent.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+}
+
+// supportedEncodings lists the content-codings Compress can produce,
+// in order of preference when the client rates them equally.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// isCompressible reports whether a body with the given Content-Type
+// value is likely to shrink when compressed.
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// parseAcceptEncoding parses an Accept-Encoding value into a map from
+// content-coding to its q-value. Codings with a malformed q-value are
+// skipped.
+func parseAcceptEncoding(s string) map[string]float64 {
+	qvalues := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if params = strings.TrimSpace(params); params != "" {
+			name, value, ok := strings.Cut(params, "=")
+			if !ok || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		qvalues[coding] = q
+	}
+	return qvalues
+}
+
+// negotiateEncoding picks the supported content-coding the client
+// prefers, per its Accept-Encoding header. It returns "" when the body
+// should be sent as is.
+func negotiateEncoding(acceptEncoding string) string {
+	qvalues := parseAcceptEncoding(acceptEncoding)
+	best, bestQ := "", 0.0
+	for _, coding := range supportedEncodings {
+		q, ok := qvalues[coding]
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Compress returns a Middleware that compresses response bodies with
+// gzip or deflate, as negotiated through the request's Accept-Encoding
+// header. Only 200 responses with a compressible Content-Type and a
+// Content-Length of at least minSize bytes (or an unknown length) are
+// compressed. Such responses get "Vary: Accept-Encoding" either way,
+// and 304 responses to them get the same Vary and ETag.
+func Compress(minSize int64) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			cw := &compressWriter{ResponseWriter: w, req: r, minSize: minSize}
+			next.ServeTriton(cw, r)
+			cw.close()
+		})
+	}
+}
+
+// compressWriter decides at WriteHeader time whether to compress the
+// body, and if so sends everything written through an encoder.
+type compressWriter struct {
+	ResponseWriter
+	req     *Request
+	minSize int64
+
+	wroteHeader bool
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	// a 304 describes the variant the client already holds, so it gets
+	// the same headers as the 200 it revalidates, minus the encoder
+	if (statusCode == 200 || statusCode == 304) && header["Content-Encoding"] == "" && isCompressible(header["Content-Type"]) {
+		length, err := strconv.ParseInt(header["Content-Length"], 10, 64)
+		if header["Content-Length"] == "" || (err == nil && length >= cw.minSize) {
+			addVary(header, "Accept-Encoding")
+			if coding := negotiateEncoding(cw.req.Headers["Accept-Encoding"]); coding != "" {
+				setEncoding(header, coding)
+				if statusCode == 200 {
+					cw.startEncoder(coding)
+				}
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// startEncoder sends the body through an encoder for the given
+// content-coding.
+func (cw *compressWriter) startEncoder(coding string) {
+	switch coding {
+	case "gzip":
+		cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+	case "deflate":
+		cw.encoder, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+	}
+}
+
+// setEncoding rewrites the headers of a response for the given
+// content-coding. The length of the encoded body is unknown, so it
+// will be sent chunked.
+func setEncoding(header map[string]string, coding string) {
+	header["Content-Encoding"] = coding
+	delete(header, "Content-Length")
+	// the encoded body is not byte-for-byte the file, so its entity-tag
+	// may only be weak; weak comparison still allows 304 responses
+	if etag, ok := header["ETag"]; ok {
+		header["ETag"] = weakETag(etag)
+	}
+	// byte ranges would refer to the encoded body
+	delete(header, "Accept-Ranges")
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(200)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the wrapped writer's ReadFrom, and with it sendfile,
+// reachable when the body is not compressed.
+func (cw *compressWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(200)
+	}
+	if cw.encoder != nil {
+		return io.Copy(cw.encoder, r)
+	}
+	if rf, ok := cw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{cw.ResponseWriter}, r)
+}
+
+// close flushes the encoder, if any, once the handler has returned.
+func (cw *compressWriter) close() {
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(header map[string]string, field string) {
+	vary := header["Vary"]
+	for _, v := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), field) {
+			return
+		}
+	}
+	if vary == "" {
+		header["Vary"] = field
+	} else {
+		header["Vary"] = vary + ", " + field
+	}
+}
@@ -0,0 +1,132 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	var tests = []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"br", ""},
+		{"GZIP;Q=0.8", "gzip"},
+		{"gzip;q=2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	s := &Server{
+		Handler: Chain(&FileServer{
+			VirtualHosts: map[string]string{"website1": "../docroot_dirs/htdocs1"},
+		}, Compress(100)),
+	}
+
+	var tests = []struct {
+		name           string
+		url            string
+		acceptEncoding string
+		encodingWant   string
+		varyWant       bool
+	}{
+		{"gzip html", "/index.html", "gzip, deflate", "gzip", true},
+		{"deflate html", "/index.html", "deflate", "deflate", true},
+		{"not accepted", "/index.html", "", "", true},
+		{"jpeg is already compressed", "/kitten.jpg", "gzip", "", false},
+		{"below threshold", "/hidden/empty.html", "gzip", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqText := "GET " + tt.url + " HTTP/1.1\r\nHost: website1\r\nConnection: close\r\n"
+			if tt.acceptEncoding != "" {
+				reqText += "Accept-Encoding: " + tt.acceptEncoding + "\r\n"
+			}
+			resp, body := serveOne(t, s, reqText+"\r\n")
+			if resp.StatusCode != 200 {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, 200)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.encodingWant {
+				t.Fatalf("Content-Encoding got: %q, want: %q", got, tt.encodingWant)
+			}
+			if got := resp.Header.Get("Vary") == "Accept-Encoding"; got != tt.varyWant {
+				t.Fatalf("Vary got: %q, want Accept-Encoding: %v", resp.Header.Get("Vary"), tt.varyWant)
+			}
+
+			var decoded io.Reader = strings.NewReader(body)
+			switch tt.encodingWant {
+			case "gzip":
+				gr, err := gzip.NewReader(decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded = gr
+				if !strings.HasPrefix(resp.Header.Get("ETag"), "W/") {
+					t.Fatalf("compressed response has strong ETag %q", resp.Header.Get("ETag"))
+				}
+			case "deflate":
+				decoded = flate.NewReader(decoded)
+			}
+			got, err := io.ReadAll(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile("../docroot_dirs/htdocs1" + tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("decoded body does not equal original file contents")
+			}
+		})
+	}
+}
+
+func TestCompressNotModified(t *testing.T) {
+	s := &Server{
+		Handler: Chain(&FileServer{
+			VirtualHosts: map[string]string{"website1": "../docroot_dirs/htdocs1"},
+		}, Compress(100)),
+	}
+
+	get := "GET /index.html HTTP/1.1\r\nHost: website1\r\nConnection: close\r\nAccept-Encoding: gzip\r\n"
+	resp, _ := serveOne(t, s, get+"\r\n")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != 200 || !strings.HasPrefix(etag, "W/") {
+		t.Fatalf("got status %v with ETag %q, want 200 with a weak ETag", resp.StatusCode, etag)
+	}
+
+	resp, body := serveOne(t, s, get+"If-None-Match: "+etag+"\r\n\r\n")
+	if resp.StatusCode != 304 {
+		t.Fatalf("status code got: %v, want: %v", resp.StatusCode, 304)
+	}
+	if got := resp.Header.Get("ETag"); got != etag {
+		t.Fatalf("ETag got: %q, want: %q", got, etag)
+	}
+	if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+		t.Fatalf("Vary got: %q, want: %q", got, "Accept-Encoding")
+	}
+	if body != "" {
+		t.Fatalf("304 response has body %q", body)
+	}
+}
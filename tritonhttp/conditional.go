@@ -0,0 +1,132 @@
+package tritonhttp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// generateETag builds a strong entity-tag for a file from its size and
+// modification time.
+func generateETag(fi os.FileInfo) string {
+	return "\"" + strconv.FormatInt(fi.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(fi.Size(), 16) + "\""
+}
+
+// weakETag returns the weak form of an entity-tag, which only promises
+// semantic equivalence, not byte-for-byte equality.
+func weakETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// parseETagList splits an If-Match / If-None-Match field value into its
+// entity-tags. It returns nil if the value is malformed.
+func parseETagList(s string) []string {
+	var tags []string
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags
+		}
+		start := 0
+		if strings.HasPrefix(s, "W/") {
+			start = 2
+		}
+		if len(s) <= start || s[start] != '"' {
+			return nil
+		}
+		end := strings.IndexByte(s[start+1:], '"')
+		if end < 0 {
+			return nil
+		}
+		end += start + 2
+		tags = append(tags, s[:end])
+		s = s[end:]
+	}
+}
+
+// etagWeakMatch compares two entity-tags using the weak comparison
+// function of RFC 9110 section 8.8.3.2: the opaque tags must be equal,
+// regardless of either being weak.
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// etagStrongMatch compares two entity-tags using the strong comparison
+// function: both must be strong and have equal opaque tags.
+func etagStrongMatch(a, b string) bool {
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+// writePreconditionsMet evaluates If-Match and If-None-Match for a
+// request that changes a file whose current entity-tag is etag, or ""
+// if it does not exist. "*" matches any existing file, so
+// "If-None-Match: *" only lets a PUT create a file and "If-Match: *"
+// only lets it replace one. If false, a 412 should be sent.
+func writePreconditionsMet(req *Request, etag string) bool {
+	if im, ok := req.Headers["If-Match"]; ok {
+		if etag == "" {
+			return false
+		}
+		if strings.TrimSpace(im) != "*" {
+			matched := false
+			for _, tag := range parseETagList(im) {
+				if etagStrongMatch(tag, etag) {
+					matched = true
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+
+	if inm, ok := req.Headers["If-None-Match"]; ok && etag != "" {
+		if strings.TrimSpace(inm) == "*" {
+			return false
+		}
+		for _, tag := range parseETagList(inm) {
+			if etagWeakMatch(tag, etag) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isNotModified evaluates If-None-Match and If-Modified-Since against
+// the current validators of the selected file, following the precedence
+// of RFC 9110 section 13.2.2. It returns true if a 304 should be sent
+// instead of the file.
+func isNotModified(req *Request, etag string, modTime time.Time) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	if inm, ok := req.Headers["If-None-Match"]; ok {
+		if strings.TrimSpace(inm) == "*" {
+			return true
+		}
+		for _, tag := range parseETagList(inm) {
+			if etagWeakMatch(tag, etag) {
+				return true
+			}
+		}
+		// If-Modified-Since is ignored when If-None-Match is present
+		return false
+	}
+
+	if ims, ok := req.Headers["If-Modified-Since"]; ok {
+		t, err := ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// Last-Modified only has one-second resolution
+		return !modTime.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
@@ -0,0 +1,67 @@
+package tritonhttp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseETagList(t *testing.T) {
+	var tests = []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"single", `"abc"`, []string{`"abc"`}},
+		{"weak and strong", `W/"abc", "def"`, []string{`W/"abc"`, `"def"`}},
+		{"comma inside tag", `"a,b" ,"c"`, []string{`"a,b"`, `"c"`}},
+		{"unquoted", `abc`, nil},
+		{"unterminated", `"abc`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseETagList(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	modTime := time.Date(2021, 10, 19, 18, 12, 55, 500, time.UTC)
+	etag := `"1-2"`
+
+	var tests = []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"no validators", "GET", map[string]string{}, false},
+		{"etag match", "GET", map[string]string{"If-None-Match": `"0", "1-2"`}, true},
+		{"weak etag match", "HEAD", map[string]string{"If-None-Match": `W/"1-2"`}, true},
+		{"etag mismatch", "GET", map[string]string{"If-None-Match": `"0"`}, false},
+		{"wildcard", "GET", map[string]string{"If-None-Match": "*"}, true},
+		{"not modified since", "GET", map[string]string{"If-Modified-Since": "Tue, 19 Oct 2021 18:12:55 GMT"}, true},
+		{"modified since", "GET", map[string]string{"If-Modified-Since": "Tue, 19 Oct 2021 18:12:54 GMT"}, false},
+		{"invalid date", "GET", map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{
+			"etag takes precedence",
+			"GET",
+			map[string]string{
+				"If-None-Match":     `"0"`,
+				"If-Modified-Since": "Tue, 19 Oct 2021 18:12:55 GMT",
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Method: tt.method, Headers: tt.headers}
+			if got := isNotModified(req, etag, modTime); got != tt.want {
+				t.Fatalf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
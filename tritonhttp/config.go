@@ -0,0 +1,348 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the server configuration read from a virtual hosts config
+// file, such as virtual_hosts.yaml.
+type Config struct {
+	// Listen optionally sets the ports to serve on
+	Listen ListenConfig `yaml:"listen"`
+
+	// Timeouts and Limits optionally override the defaults of the
+	// Server fields of the same names
+	Timeouts TimeoutConfig `yaml:"timeouts"`
+	Limits   LimitConfig   `yaml:"limits"`
+
+	// AllowEncodedSlashes sets Server.AllowEncodedSlashes
+	AllowEncodedSlashes bool `yaml:"allowEncodedSlashes"`
+
+	VirtualHosts []VirtualHostConfig `yaml:"virtual_hosts"`
+
+	// DefaultHost optionally names the virtual host that serves
+	// requests whose Host matches no other
+	DefaultHost string `yaml:"defaultHost"`
+
+	// TLS optionally names the default certificate, served when the
+	// client sends no SNI name or one without its own certificate
+	TLS *TLSFiles `yaml:"tls"`
+
+	// AccessLog optionally names the log file of hosts without their
+	// own, and AccessLogFormat selects "common", "combined" or "json"
+	AccessLog       string `yaml:"accessLog"`
+	AccessLogFormat string `yaml:"accessLogFormat"`
+
+	// Path is the file the config was loaded from, set by LoadConfig.
+	// Relative paths in the config, such as those of certificates and
+	// log files, are resolved against its directory.
+	Path string `yaml:"-"`
+}
+
+// VHConfigs is the former name of Config.
+type VHConfigs = Config
+
+// ListenConfig holds the ports to serve on. Zero leaves the choice to
+// the command line.
+type ListenConfig struct {
+	Port      int `yaml:"port"`
+	TLSPort   int `yaml:"tlsPort"`
+	AdminPort int `yaml:"adminPort"`
+
+	// AdminAddr is the address the admin listener binds to, which is
+	// 127.0.0.1 unless set
+	AdminAddr string `yaml:"adminAddr"`
+}
+
+// TimeoutConfig holds timeouts, written like "5s" or "1m30s". Zero
+// means the default.
+type TimeoutConfig struct {
+	ReadHeader time.Duration `yaml:"readHeader"`
+	Idle       time.Duration `yaml:"idle"`
+	BodyRead   time.Duration `yaml:"bodyRead"`
+	Write      time.Duration `yaml:"write"`
+}
+
+// LimitConfig holds limits on clients. Zero means the default.
+type LimitConfig struct {
+	MaxHeaderBytes     int   `yaml:"maxHeaderBytes"`
+	MaxBodyBytes       int64 `yaml:"maxBodyBytes"`
+	MaxRequestsPerConn int   `yaml:"maxRequestsPerConn"`
+	MaxConnections     int   `yaml:"maxConnections"`
+}
+
+// VirtualHostConfig is the entry of one virtual host.
+type VirtualHostConfig struct {
+	// HostName may be a wildcard pattern such as "*.example.test",
+	// which matches any subdomain of example.test
+	HostName string `yaml:"hostName"`
+	DocRoot  string `yaml:"docRoot"`
+
+	// Aliases lists other names, or wildcard patterns, of the host
+	Aliases []string `yaml:"aliases"`
+
+	// TLS optionally names the certificate served to clients that
+	// ask for this host via SNI
+	TLS *TLSFiles `yaml:"tls"`
+
+	// AccessLog optionally names the file this host's requests
+	// are logged to
+	AccessLog string `yaml:"accessLog"`
+
+	HostOptions `yaml:",inline"`
+
+	// DocRootPath is DocRoot resolved against the docroot directory,
+	// and Line is where the entry starts in the config file. Both are
+	// set by LoadConfig.
+	DocRootPath string `yaml:"-"`
+	Line        int    `yaml:"-"`
+}
+
+// TLSFiles names a PEM certificate and private key pair. Relative
+// paths are resolved against the directory of the config file.
+type TLSFiles struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// ConfigError lists every problem found in a config file.
+type ConfigError struct {
+	Path   string
+	Errors []string // e.g. "line 4: duplicate hostName ..."
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config " + e.Path + ":\n  " + strings.Join(e.Errors, "\n  ")
+}
+
+// LoadConfig reads and validates the config file at path. Unknown or
+// repeated fields, virtual hosts without a hostName or docRoot,
+// duplicate hostNames, and docRoots that are not directories are all
+// reported, with line numbers, in a single *ConfigError, as are names
+// and aliases used twice (ignoring case), malformed wildcard patterns
+// and a defaultHost that names no host. Each docRoot is resolved
+// against docrootDirsPath.
+func LoadConfig(path string, docrootDirsPath string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{Path: path}
+	var errs []string
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			// a syntax error leaves nothing to validate
+			return nil, &ConfigError{Path: path, Errors: []string{strings.TrimPrefix(err.Error(), "yaml: ")}}
+		}
+		errs = append(errs, typeErr.Errors...)
+	}
+
+	lines := entryLines(data)
+	// names and aliases are matched ignoring case, so compare them
+	// normalized
+	firstUse := make(map[string]string)
+	for i := range config.VirtualHosts {
+		vhost := &config.VirtualHosts[i]
+		where := fmt.Sprintf("virtual host #%d", i+1)
+		if i < len(lines) {
+			vhost.Line = lines[i]
+			where = fmt.Sprintf("line %d", vhost.Line)
+		}
+
+		if vhost.HostName == "" {
+			errs = append(errs, where+": virtual host has no hostName")
+		}
+		for j, name := range append([]string{vhost.HostName}, vhost.Aliases...) {
+			if name == "" {
+				continue
+			}
+			what := "hostName"
+			if j > 0 {
+				what = "alias"
+			}
+			if !isHostPattern(name) {
+				errs = append(errs, fmt.Sprintf("%s: %s %q is not a valid host name or wildcard pattern", where, what, name))
+				continue
+			}
+			key := normalizeHost(name)
+			if first, ok := firstUse[key]; ok {
+				errs = append(errs, fmt.Sprintf("%s: duplicate %s %q, first used %s", where, what, name, first))
+				continue
+			}
+			firstUse[key] = fmt.Sprintf("by %q", vhost.HostName)
+			if vhost.Line > 0 {
+				firstUse[key] = fmt.Sprintf("on line %d", vhost.Line)
+			}
+		}
+
+		for _, prefix := range vhost.WritablePaths {
+			if !strings.HasPrefix(prefix, "/") {
+				errs = append(errs, fmt.Sprintf("%s: writablePaths entry %q does not start with /", where, prefix))
+			}
+		}
+
+		if upload := vhost.Upload; upload != nil && !strings.HasPrefix(upload.Path, "/") {
+			errs = append(errs, fmt.Sprintf("%s: upload path %q does not start with /", where, upload.Path))
+		}
+
+		if vhost.DocRoot == "" {
+			errs = append(errs, where+": virtual host has no docRoot")
+			continue
+		}
+		vhost.DocRootPath = filepath.Join(docrootDirsPath, vhost.DocRoot)
+		fi, err := os.Stat(vhost.DocRootPath)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: docRoot %s does not exist", where, vhost.DocRootPath))
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Sprintf("%s: docRoot %s is not a directory", where, vhost.DocRootPath))
+		} else if vhost.Upload != nil {
+			uploadDir := filepath.Join(vhost.DocRootPath, filepath.Clean("/"+vhost.Upload.Dir))
+			if fi, err := os.Stat(uploadDir); err != nil || !fi.IsDir() {
+				errs = append(errs, fmt.Sprintf("%s: upload dir %s is not a directory", where, uploadDir))
+			}
+		}
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int64
+	}{
+		{"timeouts.readHeader", int64(config.Timeouts.ReadHeader)},
+		{"timeouts.idle", int64(config.Timeouts.Idle)},
+		{"timeouts.bodyRead", int64(config.Timeouts.BodyRead)},
+		{"timeouts.write", int64(config.Timeouts.Write)},
+		{"limits.maxHeaderBytes", int64(config.Limits.MaxHeaderBytes)},
+		{"limits.maxBodyBytes", config.Limits.MaxBodyBytes},
+		{"limits.maxRequestsPerConn", int64(config.Limits.MaxRequestsPerConn)},
+		{"limits.maxConnections", int64(config.Limits.MaxConnections)},
+	} {
+		if setting.value < 0 {
+			errs = append(errs, setting.name+" must not be negative")
+		}
+	}
+
+	if config.DefaultHost != "" && !config.hasHost(config.DefaultHost) {
+		errs = append(errs, fmt.Sprintf("defaultHost %q is not the hostName of a virtual host", config.DefaultHost))
+	}
+
+	switch config.AccessLogFormat {
+	case "", LogCommon, LogCombined, LogJSON:
+	default:
+		errs = append(errs, fmt.Sprintf("unknown accessLogFormat %q", config.AccessLogFormat))
+	}
+
+	if len(errs) > 0 {
+		return nil, &ConfigError{Path: path, Errors: errs}
+	}
+	return config, nil
+}
+
+// ApplyTo copies the timeouts, limits and request path options of c
+// to s.
+func (c *Config) ApplyTo(s *Server) {
+	s.ReadHeaderTimeout = c.Timeouts.ReadHeader
+	s.IdleTimeout = c.Timeouts.Idle
+	s.BodyReadTimeout = c.Timeouts.BodyRead
+	s.WriteTimeout = c.Timeouts.Write
+	s.MaxHeaderBytes = c.Limits.MaxHeaderBytes
+	s.MaxBodyBytes = c.Limits.MaxBodyBytes
+	s.MaxRequestsPerConn = c.Limits.MaxRequestsPerConn
+	s.MaxConnections = c.Limits.MaxConnections
+	s.AllowEncodedSlashes = c.AllowEncodedSlashes
+}
+
+// VirtualHostMap maps each host name to its docRoot path, as used by
+// Server.VirtualHosts and FileServer.VirtualHosts.
+func (c *Config) VirtualHostMap() map[string]string {
+	vh_map := make(map[string]string)
+	for _, vhost := range c.VirtualHosts {
+		vh_map[vhost.HostName] = vhost.DocRootPath
+	}
+	return vh_map
+}
+
+// HostOptionMap maps each host name to its HostOptions, as used by
+// FileServer.HostOptions.
+func (c *Config) HostOptionMap() map[string]HostOptions {
+	options := make(map[string]HostOptions)
+	for _, vhost := range c.VirtualHosts {
+		options[vhost.HostName] = vhost.HostOptions
+	}
+	return options
+}
+
+// HostTable returns a HostTable resolving requests to the virtual
+// hosts, their aliases and the default host.
+func (c *Config) HostTable() *HostTable {
+	names := make([]string, 0, len(c.VirtualHosts))
+	aliases := make(map[string][]string)
+	for _, vhost := range c.VirtualHosts {
+		names = append(names, vhost.HostName)
+		aliases[vhost.HostName] = vhost.Aliases
+	}
+	return NewHostTable(names, aliases, c.DefaultHost)
+}
+
+// resolvePath resolves a path from the config file against the
+// directory of that file.
+func (c *Config) resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(c.Path), path)
+}
+
+func (c *Config) hasHost(hostName string) bool {
+	for _, vhost := range c.VirtualHosts {
+		if vhost.HostName == hostName {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostPattern reports whether name is a host name, optionally with a
+// "*." wildcard in front, and nothing else.
+func isHostPattern(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	if name == "" || strings.ContainsAny(name, "*/: \t") {
+		return false
+	}
+	return true
+}
+
+// entryLines returns the line numbers where the items of the top-level
+// virtual_hosts sequence start, for error messages. It only follows
+// block style; items written in flow style are not found.
+func entryLines(data []byte) []int {
+	var lines []int
+	inHosts := false
+	itemIndent := -1
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		isItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+		if indent == 0 && !isItem {
+			// a new top-level key
+			inHosts = strings.HasPrefix(trimmed, "virtual_hosts:")
+			itemIndent = -1
+			continue
+		}
+		if inHosts && isItem && (itemIndent == -1 || indent == itemIndent) {
+			itemIndent = indent
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
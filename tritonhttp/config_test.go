@@ -0,0 +1,157 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "virtual_hosts.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	docrootDirs := t.TempDir()
+	for _, dir := range []string{"one", "two"} {
+		if err := os.Mkdir(filepath.Join(docrootDirs, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(docrootDirs, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTestConfig(t, "listen:\n"+
+		"  port: 8081\n"+
+		"timeouts:\n"+
+		"  readHeader: 10s\n"+
+		"limits:\n"+
+		"  maxConnections: 100\n"+
+		"virtual_hosts:\n"+
+		"  # the first site\n"+
+		"  - hostName: \"one\"\n"+
+		"    docRoot: \"one\"\n"+
+		"    indexFiles: [\"home.html\"]\n"+
+		"  - hostName: \"two\"\n"+
+		"    docRoot: \"two\"\n")
+	config, err := LoadConfig(path, docrootDirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Listen.Port != 8081 || config.Timeouts.ReadHeader != 10*time.Second || config.Limits.MaxConnections != 100 {
+		t.Fatalf("config got: %+v", config)
+	}
+	s := &Server{}
+	config.ApplyTo(s)
+	if s.ReadHeaderTimeout != 10*time.Second || s.MaxConnections != 100 {
+		t.Fatalf("server got: %+v", s)
+	}
+	wantHosts := map[string]string{"one": filepath.Join(docrootDirs, "one"), "two": filepath.Join(docrootDirs, "two")}
+	if got := config.VirtualHostMap(); !reflect.DeepEqual(got, wantHosts) {
+		t.Fatalf("virtual hosts got: %v, want: %v", got, wantHosts)
+	}
+	if got := config.HostOptionMap()["one"].IndexFiles; !reflect.DeepEqual(got, []string{"home.html"}) {
+		t.Fatalf("index files got: %v", got)
+	}
+	if config.VirtualHosts[0].Line != 9 || config.VirtualHosts[1].Line != 12 {
+		t.Fatalf("lines got: %v and %v, want: 9 and 12", config.VirtualHosts[0].Line, config.VirtualHosts[1].Line)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	docrootDirs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(docrootDirs, "one"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(docrootDirs, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		config     string
+		errorsWant []string
+	}{
+		{
+			"every problem is reported",
+			"virtual_hosts:\n" +
+				"  - hostName: \"one\"\n" +
+				"    docRoot: \"one\"\n" +
+				"    autoIndx: true\n" +
+				"  - hostName: \"one\"\n" +
+				"    docRoot: \"file\"\n" +
+				"  - docRoot: \"missing\"\n" +
+				"  - hostName: \"three\"\n" +
+				"accessLogFormat: \"xml\"\n",
+			[]string{
+				"line 4: field autoIndx not found",
+				"line 5: duplicate hostName \"one\", first used on line 2",
+				"line 5: docRoot " + filepath.Join(docrootDirs, "file") + " is not a directory",
+				"line 7: virtual host has no hostName",
+				"line 7: docRoot " + filepath.Join(docrootDirs, "missing") + " does not exist",
+				"line 8: virtual host has no docRoot",
+				"unknown accessLogFormat \"xml\"",
+			},
+		},
+		{
+			"conflicting names",
+			"defaultHost: \"two\"\n" +
+				"virtual_hosts:\n" +
+				"  - hostName: \"one\"\n" +
+				"    docRoot: \"one\"\n" +
+				"    aliases: [\"www.one\", \"*.one.test\", \"bad*.one\"]\n" +
+				"  - hostName: \"WWW.ONE\"\n" +
+				"    docRoot: \"one\"\n" +
+				"    aliases: [\"*.One.Test\"]\n" +
+				"limits:\n" +
+				"  maxConnections: -1\n",
+			[]string{
+				"line 3: alias \"bad*.one\" is not a valid host name or wildcard pattern",
+				"line 6: duplicate hostName \"WWW.ONE\", first used on line 3",
+				"line 6: duplicate alias \"*.One.Test\", first used on line 3",
+				"limits.maxConnections must not be negative",
+				"defaultHost \"two\" is not the hostName of a virtual host",
+			},
+		},
+		{
+			"unknown top-level field",
+			"virtual_host:\n  - hostName: \"one\"\n",
+			[]string{"line 1: field virtual_host not found"},
+		},
+		{
+			"repeated field",
+			"virtual_hosts:\n  - hostName: \"one\"\n    hostName: \"two\"\n    docRoot: \"one\"\n",
+			[]string{"line 3: field hostName already set"},
+		},
+		{
+			"syntax error",
+			"virtual_hosts:\n  - hostName: \"one\n",
+			[]string{"line 3: found unexpected end of stream"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeTestConfig(t, tt.config), docrootDirs)
+			configErr, ok := err.(*ConfigError)
+			if !ok {
+				t.Fatalf("got error %v, want *ConfigError", err)
+			}
+			if len(configErr.Errors) != len(tt.errorsWant) {
+				t.Fatalf("errors got: %q, want: %q", configErr.Errors, tt.errorsWant)
+			}
+			for i, want := range tt.errorsWant {
+				if !strings.HasPrefix(configErr.Errors[i], want) {
+					t.Fatalf("error %d got: %q, want prefix: %q", i, configErr.Errors[i], want)
+				}
+			}
+		})
+	}
+}
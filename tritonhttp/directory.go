@@ -0,0 +1,153 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findIndexFile returns the first of names that is a regular file in
+// dir, or a nil FileInfo if there is none.
+func findIndexFile(dir string, names []string) (string, os.FileInfo) {
+	for _, name := range names {
+		indexPath := filepath.Join(dir, filepath.Base(name))
+		fi, err := os.Stat(indexPath)
+		if err == nil && fi.Mode().IsRegular() {
+			return indexPath, fi
+		}
+	}
+	return "", nil
+}
+
+// dirEntry describes one entry of a directory listing.
+type dirEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// readDirEntries lists dir, skipping dot files, sorted by the "sort"
+// query parameter ("name", "size" or "mtime") in the direction given
+// by "order" ("asc" or "desc"). Directories always come first.
+func readDirEntries(dir string, query url.Values) ([]dirEntry, error) {
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dirEntry, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		fi, err := info.Info()
+		if err != nil {
+			continue
+		}
+		entry := dirEntry{Name: fi.Name(), IsDir: fi.IsDir(), ModTime: fi.ModTime().UTC()}
+		if !fi.IsDir() {
+			entry.Size = fi.Size()
+		}
+		entries = append(entries, entry)
+	}
+
+	less := func(a, b dirEntry) bool { return a.Name < b.Name }
+	switch query.Get("sort") {
+	case "size":
+		less = func(a, b dirEntry) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b dirEntry) bool { return a.ModTime.Before(b.ModTime) }
+	}
+	desc := query.Get("order") == "desc"
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return entries, nil
+}
+
+// acceptsJSON reports whether the request's Accept header asks for JSON.
+func acceptsJSON(req *Request) bool {
+	for _, mediaRange := range strings.Split(req.Headers["Accept"], ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+			return true
+		}
+	}
+	return false
+}
+
+// renderDirHTML renders entries as an HTML page for the directory at urlPath.
+func renderDirHTML(urlPath string, entries []dirEntry) []byte {
+	var b bytes.Buffer
+	title := html.EscapeString("Index of " + urlPath)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + title + "</title>\n</head>\n<body>\n")
+	b.WriteString("<h1>" + title + "</h1>\n<table>\n")
+	b.WriteString("<tr><th><a href=\"?sort=name\">Name</a></th><th><a href=\"?sort=size\">Size</a></th><th><a href=\"?sort=mtime\">Last modified</a></th></tr>\n")
+	if urlPath != "/" {
+		b.WriteString("<tr><td><a href=\"../\">../</a></td><td></td><td></td></tr>\n")
+	}
+	for _, entry := range entries {
+		name, size := entry.Name, strconv.FormatInt(entry.Size, 10)
+		if entry.IsDir {
+			name, size = name+"/", "-"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(name, ":") {
+			// keep a colon in the name from reading as a URL scheme
+			href = "./" + href
+		}
+		b.WriteString("<tr><td><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></td>" +
+			"<td>" + size + "</td><td>" + FormatTime(entry.ModTime) + "</td></tr>\n")
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// handleAutoIndex lists the directory at absolutePath, as JSON if the
+// client asks for it and as HTML otherwise.
+func (fs *FileServer) handleAutoIndex(req *Request, absolutePath string) (res *Response) {
+	urlPath, rawQuery := req.target()
+	query, _ := url.ParseQuery(rawQuery)
+	entries, err := readDirEntries(absolutePath, query)
+	if err != nil {
+		return fs.handleStatError(req, err)
+	}
+
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 200
+	res.StatusText = "OK"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	res.Headers["Vary"] = "Accept"
+	var body []byte
+	if acceptsJSON(req) {
+		body, _ = json.Marshal(entries)
+		body = append(body, '\n')
+		res.Headers["Content-Type"] = "application/json"
+	} else {
+		body = renderDirHTML(urlPath, entries)
+		res.Headers["Content-Type"] = "text/html; charset=utf-8"
+	}
+	res.Headers["Content-Length"] = strconv.Itoa(len(body))
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.Body = bytes.NewReader(body)
+	return res
+}
@@ -0,0 +1,70 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errorPageTemplate is the body of an error response when the virtual
+// host has no page of its own for the status. Both verbs are filled in
+// with the status line, e.g. "404 Not Found".
+const errorPageTemplate = `<!DOCTYPE html>
+<html>
+<head><title>%s</title></head>
+<body>
+<h1>%s</h1>
+<hr>
+<p>TritonHTTP</p>
+</body>
+</html>
+`
+
+// defaultErrorPage renders errorPageTemplate for statusCode.
+func defaultErrorPage(statusCode int) string {
+	status := html.EscapeString(strconv.Itoa(statusCode) + " " + StatusText(statusCode))
+	return fmt.Sprintf(errorPageTemplate, status, status)
+}
+
+// setDefaultErrorPage makes the built-in page for res.StatusCode the
+// body of res.
+func (res *Response) setDefaultErrorPage() {
+	page := defaultErrorPage(res.StatusCode)
+	res.Headers["Content-Type"] = "text/html; charset=utf-8"
+	res.Headers["Content-Length"] = strconv.Itoa(len(page))
+	res.Body = strings.NewReader(page)
+	res.FilePath = ""
+}
+
+// setErrorPage replaces the body of the error response res with the
+// page that the virtual host of res.Request configures for its status,
+// if there is one and it is a readable regular file inside the docRoot.
+// Otherwise res keeps the built-in page.
+func (fs *FileServer) setErrorPage(res *Response) {
+	if res.Request == nil {
+		return
+	}
+	hostName := fs.virtualHost(res.Request)
+	docRoot := fs.VirtualHosts[hostName]
+	page := fs.HostOptions[hostName].ErrorPages[res.StatusCode]
+	if docRoot == "" || page == "" {
+		return
+	}
+	pagePath := filepath.Join(docRoot, filepath.Clean("/"+page))
+	fi, err := os.Stat(pagePath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	file, err := os.Open(pagePath)
+	if err != nil {
+		return
+	}
+	file.Close()
+	res.Headers["Content-Type"] = MIMETypeByExtension(filepath.Ext(pagePath))
+	res.Headers["Content-Length"] = strconv.FormatInt(fi.Size(), 10)
+	res.Body = nil
+	res.FilePath = pagePath
+}
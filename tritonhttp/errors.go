@@ -0,0 +1,26 @@
+package tritonhttp
+
+import "strconv"
+
+// ProtocolError is returned by ReadRequest when a request cannot be
+// served. StatusCode is the status of the error response to send.
+type ProtocolError struct {
+	StatusCode int    // e.g. 400
+	Reason     string // e.g. "missing Host header"
+
+	// Err is the read error that cut the request short, if any, such
+	// as the timeout of a partial request.
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	return strconv.Itoa(e.StatusCode) + " " + StatusText(e.StatusCode) + ": " + e.Reason
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(reason string) *ProtocolError {
+	return &ProtocolError{StatusCode: 400, Reason: reason}
+}
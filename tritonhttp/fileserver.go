@@ -0,0 +1,318 @@
+package tritonhttp
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileServer is a Handler that serves static files from the docRoot of
+// the virtual host named by each request's Host header.
+type FileServer struct {
+	// VirtualHosts contains a mapping from host name to the docRoot path
+	// for all virtual hosts that this file server serves.
+	VirtualHosts map[string]string
+
+	// HostOptions optionally holds per-virtual-host settings, keyed by
+	// host name. Hosts without an entry use the zero HostOptions.
+	HostOptions map[string]HostOptions
+
+	// Hosts optionally resolves the Host header of requests to the host
+	// names in VirtualHosts, adding aliases and a default host. If nil,
+	// the keys of VirtualHosts are matched, ignoring case and port.
+	Hosts *HostTable
+
+	hostsOnce sync.Once
+	hosts     *HostTable // Hosts, or built from VirtualHosts on first use
+}
+
+func (fs *FileServer) ServeTriton(w ResponseWriter, req *Request) {
+	serveResponse(w, fs.serveFile(req))
+}
+
+// serveFile picks the response for a valid request to a static file.
+func (fs *FileServer) serveFile(req *Request) *Response {
+	// "OPTIONS *" asks about the virtual host rather than a resource
+	if req.URL == "*" {
+		if fs.VirtualHosts[fs.virtualHost(req)] == "" {
+			return fs.handle404Requests(req)
+		}
+		return fs.handleOptions(req)
+	}
+
+	switch req.Method {
+	case "GET", "HEAD":
+	case "POST":
+		return fs.serveUpload(req)
+	case "PUT", "DELETE":
+		return fs.serveWrite(req)
+	case "OPTIONS", "PROPFIND", "MKCOL", "COPY", "MOVE":
+		return fs.serveWebDAV(req)
+	default:
+		return fs.handle405Requests(req)
+	}
+
+	hostName, _, absolutePath, ok := fs.resolve(req)
+	if !ok {
+		return fs.handle404Requests(req)
+	}
+
+	fi, err := os.Stat(absolutePath)
+	if err != nil {
+		return fs.handleStatError(req, err)
+	}
+
+	// a directory is served through its index file, or else listed;
+	// its URL must end in a slash so relative links resolve inside it
+	if fi.IsDir() {
+		rawPath, rawQuery, hasQuery := strings.Cut(req.URL, "?")
+		if !strings.HasSuffix(rawPath, "/") {
+			location := rawPath + "/"
+			if hasQuery {
+				location += "?" + rawQuery
+			}
+			return fs.handle301Requests(req, location)
+		}
+		options := fs.HostOptions[hostName]
+		indexPath, indexFi := findIndexFile(absolutePath, options.indexFiles())
+		if indexFi == nil {
+			if options.AutoIndex {
+				return fs.handleAutoIndex(req, absolutePath)
+			}
+			return fs.handle404Requests(req)
+		}
+		// serve the index file as if it had been requested directly;
+		// req is left as sent, for the handlers around this one
+		absolutePath, fi = indexPath, indexFi
+	}
+
+	// make sure the file can be opened before committing to a status
+	file, err := os.Open(absolutePath)
+	if err != nil {
+		return fs.handleStatError(req, err)
+	}
+	file.Close()
+
+	// if the client's cached copy is still valid, 304 not modified
+	// else if only part of the file is requested, 206 or 416
+	// else, 200 ok
+	etag := generateETag(fi)
+	if sc, _ := selectSidecar(req, absolutePath, fi); sc != nil {
+		etag = sc.etag()
+	}
+	if isNotModified(req, etag, fi.ModTime()) {
+		return fs.handle304Requests(req, absolutePath, fi)
+	} else if ranges, err := selectRanges(req, fi); err != nil {
+		return fs.handle416Requests(req, fi)
+	} else if ranges != nil {
+		return fs.handle206Requests(req, absolutePath, fi, ranges)
+	}
+	return fs.handle200Requests(req, absolutePath, fi)
+}
+
+// virtualHost returns the name of the virtual host that req is for, or
+// "" if there is none.
+func (fs *FileServer) virtualHost(req *Request) string {
+	return fs.Lookup(req.Host)
+}
+
+// Lookup returns the name of the virtual host that host resolves to,
+// or "" if there is none.
+func (fs *FileServer) Lookup(host string) string {
+	fs.hostsOnce.Do(func() {
+		fs.hosts = fs.Hosts
+		if fs.hosts == nil {
+			fs.hosts = hostTableFor(fs.VirtualHosts)
+		}
+	})
+	return fs.hosts.Lookup(host)
+}
+
+// localPath maps the decoded path of req's URL to a path under the
+// docRoot of req's virtual host.
+func (fs *FileServer) localPath(req *Request) string {
+	urlPath, _ := req.target()
+	return filepath.Join(fs.VirtualHosts[fs.virtualHost(req)], filepath.Clean("/"+urlPath))
+}
+
+// resolve finds the virtual host of req and the path its URL maps to.
+// ok is false if the host is not in VirtualHosts or the path escapes
+// its docRoot, in which case the request gets a 404.
+func (fs *FileServer) resolve(req *Request) (hostName, docRoot, absolutePath string, ok bool) {
+	hostName = fs.virtualHost(req)
+	docRoot = fs.VirtualHosts[hostName]
+	if docRoot == "" {
+		return hostName, "", "", false
+	}
+	absolutePath = fs.localPath(req)
+	if absolutePath[:len(docRoot)] != docRoot {
+		return hostName, docRoot, "", false
+	}
+	return hostName, docRoot, absolutePath, true
+}
+
+func (fs *FileServer) handle200Requests(req *Request, absolutePath string, fi os.FileInfo) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 200
+	res.StatusText = "OK"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	res.Headers["Last-Modified"] = FormatTime(fi.ModTime())
+	res.Headers["ETag"] = generateETag(fi)
+	res.Headers["Content-Type"] = MIMETypeByExtension(filepath.Ext(absolutePath))
+	res.Headers["Content-Length"] = strconv.FormatInt(fi.Size(), 10)
+	res.Headers["Accept-Ranges"] = "bytes"
+	res.FilePath = absolutePath
+	// serve a fresh precompressed copy instead, if the client accepts it;
+	// Content-Type and Last-Modified still describe the original file
+	sc, vary := selectSidecar(req, absolutePath, fi)
+	if vary {
+		res.Headers["Vary"] = "Accept-Encoding"
+	}
+	if sc != nil {
+		res.Headers["ETag"] = sc.etag()
+		res.Headers["Content-Encoding"] = sc.coding
+		res.Headers["Content-Length"] = strconv.FormatInt(sc.fi.Size(), 10)
+		res.FilePath = sc.path
+	}
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	return res
+}
+
+func (fs *FileServer) handle206Requests(req *Request, absolutePath string, fi os.FileInfo, ranges []byteRange) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 206
+	res.StatusText = "Partial Content"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	res.Headers["Last-Modified"] = FormatTime(fi.ModTime())
+	res.Headers["ETag"] = generateETag(fi)
+	res.Headers["Accept-Ranges"] = "bytes"
+	if _, vary := selectSidecar(req, absolutePath, fi); vary {
+		res.Headers["Vary"] = "Accept-Encoding"
+	}
+	contentType := MIMETypeByExtension(filepath.Ext(absolutePath))
+	if len(ranges) == 1 {
+		res.Headers["Content-Type"] = contentType
+		res.Headers["Content-Range"] = ranges[0].contentRange(fi.Size())
+		res.Headers["Content-Length"] = strconv.FormatInt(ranges[0].length, 10)
+	} else {
+		// every part gets its own headers; the length must account for
+		// them and for the boundary delimiters
+		res.Boundary = multipartBoundary()
+		res.Headers["Content-Type"] = "multipart/byteranges; boundary=" + res.Boundary
+		var length int64
+		for i := range ranges {
+			ranges[i].header = "Content-Type: " + contentType + "\r\n" +
+				"Content-Range: " + ranges[i].contentRange(fi.Size()) + "\r\n"
+			length += int64(len("\r\n--"+res.Boundary+"\r\n"+ranges[i].header+"\r\n")) + ranges[i].length
+		}
+		length += int64(len("\r\n--" + res.Boundary + "--\r\n"))
+		res.Headers["Content-Length"] = strconv.FormatInt(length, 10)
+	}
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.FilePath = absolutePath
+	res.Ranges = ranges
+	return res
+}
+
+func (fs *FileServer) handle416Requests(req *Request, fi os.FileInfo) (res *Response) {
+	res = fs.handleErrorRequests(req, 416)
+	res.Headers["Content-Range"] = "bytes */" + strconv.FormatInt(fi.Size(), 10)
+	return res
+}
+
+func (fs *FileServer) handle304Requests(req *Request, absolutePath string, fi os.FileInfo) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 304
+	res.StatusText = "Not Modified"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	// a 304 carries the validators but never a body; the representation
+	// headers of the 200 are kept so that middleware such as Compress
+	// can describe the same variant
+	res.Headers["Last-Modified"] = FormatTime(fi.ModTime())
+	res.Headers["ETag"] = generateETag(fi)
+	res.Headers["Content-Type"] = MIMETypeByExtension(filepath.Ext(absolutePath))
+	res.Headers["Content-Length"] = strconv.FormatInt(fi.Size(), 10)
+	sc, vary := selectSidecar(req, absolutePath, fi)
+	if vary {
+		res.Headers["Vary"] = "Accept-Encoding"
+	}
+	if sc != nil {
+		res.Headers["ETag"] = sc.etag()
+		res.Headers["Content-Encoding"] = sc.coding
+		res.Headers["Content-Length"] = strconv.FormatInt(sc.fi.Size(), 10)
+	}
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.FilePath = ""
+	return res
+}
+
+func (fs *FileServer) handle301Requests(req *Request, location string) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 301
+	res.StatusText = "Moved Permanently"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	res.Headers["Location"] = location
+	res.Headers["Content-Length"] = "0"
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.FilePath = ""
+	return res
+}
+
+func (fs *FileServer) handle404Requests(req *Request) (res *Response) {
+	return fs.handleErrorRequests(req, 404)
+}
+
+func (fs *FileServer) handle405Requests(req *Request) (res *Response) {
+	res = fs.handleErrorRequests(req, 405)
+	res.Headers["Allow"] = fs.allowedMethods(req)
+	return res
+}
+
+// handleStatError picks the error response for a failure to stat or
+// open the requested file: 404 if it does not exist, 403 if it may not
+// be read, and 500 for anything unexpected.
+func (fs *FileServer) handleStatError(req *Request, err error) (res *Response) {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, syscall.ENOTDIR):
+		return fs.handle404Requests(req)
+	case errors.Is(err, os.ErrPermission):
+		return fs.handleErrorRequests(req, 403)
+	default:
+		log.Println("file error: ", err)
+		return fs.handleErrorRequests(req, 500)
+	}
+}
+
+// handleErrorRequests builds an error response whose body is the
+// virtual host's page for statusCode, or else the built-in page.
+func (fs *FileServer) handleErrorRequests(req *Request, statusCode int) (res *Response) {
+	res = newErrorResponse(req, statusCode)
+	fs.setErrorPage(res)
+	return res
+}
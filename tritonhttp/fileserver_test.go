@@ -0,0 +1,409 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	contentTypeHTML = "text/html; charset=utf-8"
+	contentTypeJPG  = "image/jpeg"
+	contentTypePNG  = "image/png"
+)
+
+func TestHandleGoodRequest(t *testing.T) {
+	var tests = []struct {
+		name             string
+		req              *Request
+		statusWant       int
+		headersWant      []string
+		headerValuesWant map[string]string
+		filePathWant     string // relative to doc root
+	}{
+		{
+			"OKBasic",
+			&Request{
+				Method:  "GET",
+				URL:     "/index.html",
+				Proto:   "HTTP/1.1",
+				Headers: map[string]string{},
+				Host:    "website1",
+				Close:   false,
+			},
+			200,
+			[]string{
+				"Date",
+				"Last-Modified",
+				"ETag",
+			},
+			map[string]string{
+				"Content-Type":   contentTypeHTML,
+				"Content-Length": "377",
+			},
+			"../docroot_dirs/htdocs1/index.html",
+		},
+		{
+			"OKHead",
+			&Request{
+				Method:  "HEAD",
+				URL:     "/index.html",
+				Proto:   "HTTP/1.1",
+				Headers: map[string]string{},
+				Host:    "website1",
+				Close:   false,
+			},
+			200,
+			[]string{
+				"Date",
+				"Last-Modified",
+				"ETag",
+			},
+			map[string]string{
+				"Content-Type":   contentTypeHTML,
+				"Content-Length": "377",
+			},
+			"../docroot_dirs/htdocs1/index.html",
+		},
+	}
+	virtualHosts := ParseVHConfigFile("../virtual_hosts.yaml", "../docroot_dirs")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &FileServer{
+				VirtualHosts: virtualHosts,
+			}
+			absolutePath := fs.localPath(tt.req)
+			fi, err := os.Stat(absolutePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := fs.handle200Requests(tt.req, absolutePath, fi)
+			if res.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", res.StatusCode, tt.statusWant)
+			}
+			for _, h := range tt.headersWant {
+				if _, ok := res.Headers[h]; !ok {
+					t.Fatalf("missing header %q", h)
+				}
+			}
+			for h, vWant := range tt.headerValuesWant {
+				v, ok := res.Headers[h]
+				if !ok {
+					t.Fatalf("missing header %q", h)
+				}
+				if v != vWant {
+					t.Fatalf("header %q value got: %q, want %q", h, v, vWant)
+				}
+			}
+			if tt.filePathWant != "" {
+				// Case with file to serve
+				if res.FilePath != tt.filePathWant {
+					t.Fatalf("file path (relative to testdata/) got: %q, want: %q", res.FilePath, tt.filePathWant)
+				}
+			} else {
+				// Case with no file to serve
+				if res.FilePath != tt.filePathWant {
+					t.Fatalf("file path got: %q, want: %q", res.FilePath, tt.filePathWant)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	docRoot := t.TempDir()
+	for _, dir := range []string{"plain", "custom", "listed/sub"} {
+		if err := os.MkdirAll(filepath.Join(docRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"plain/index.htm":     "htm index",
+		"custom/home.html":    "custom index",
+		"custom/index.html":   "not the index",
+		"listed/big.txt":      "0123456789",
+		"listed/small.txt":    "0",
+		"listed/a <b>&c.txt":  "escaped",
+		"listed/.hidden.txt":  "hidden",
+		"listed/sub/file.txt": "in sub",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{Handler: &FileServer{
+		VirtualHosts: map[string]string{"test": docRoot, "listing": docRoot},
+		HostOptions: map[string]HostOptions{
+			"test":    {IndexFiles: []string{"home.html", "index.htm"}},
+			"listing": {AutoIndex: true},
+		},
+	}}
+
+	var tests = []struct {
+		name         string
+		host         string
+		url          string
+		headers      string
+		statusWant   int
+		locationWant string
+		bodyWant     string
+	}{
+		{"redirect to trailing slash", "test", "/plain", "", 301, "/plain/", ""},
+		{"redirect keeps query", "test", "/plain?v=2", "", 301, "/plain/?v=2", ""},
+		{"second index file", "test", "/plain/", "", 200, "", "htm index"},
+		{"first index file wins", "test", "/custom/", "", 200, "", "custom index"},
+		{"no index without autoindex", "test", "/listed/", "", 404, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serveOne(t, s, "GET "+tt.url+" HTTP/1.1\r\nHost: "+tt.host+"\r\nConnection: close\r\n"+tt.headers+"\r\n")
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
+			}
+			if got := resp.Header.Get("Location"); got != tt.locationWant {
+				t.Fatalf("Location got: %q, want: %q", got, tt.locationWant)
+			}
+			if !strings.HasPrefix(body, tt.bodyWant) {
+				t.Fatalf("body got: %q, want prefix: %q", body, tt.bodyWant)
+			}
+		})
+	}
+
+	t.Run("index leaves the request unchanged", func(t *testing.T) {
+		req := &Request{Method: "GET", URL: "/plain/?v=2", Path: "/plain/", RawQuery: "v=2", Proto: "HTTP/1.1", Headers: map[string]string{}, Host: "test"}
+		res := s.Handler.(*FileServer).serveFile(req)
+		if res.StatusCode != 200 || req.URL != "/plain/?v=2" || req.Path != "/plain/" {
+			t.Fatalf("got: %v with URL %q and Path %q, want: 200 with /plain/?v=2 and /plain/", res.StatusCode, req.URL, req.Path)
+		}
+	})
+
+	t.Run("json listing", func(t *testing.T) {
+		resp, body := serveOne(t, s, "GET /listed/?sort=size&order=desc HTTP/1.1\r\nHost: listing\r\nConnection: close\r\nAccept: application/json\r\n\r\n")
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("Content-Type got: %q", resp.Header.Get("Content-Type"))
+		}
+		var entries []dirEntry
+		if err := json.Unmarshal([]byte(body), &entries); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		want := []string{"sub", "big.txt", "a <b>&c.txt", "small.txt"}
+		if !reflect.DeepEqual(names, want) {
+			t.Fatalf("names got: %q, want: %q", names, want)
+		}
+	})
+
+	t.Run("html listing", func(t *testing.T) {
+		resp, body := serveOne(t, s, "GET /listed/ HTTP/1.1\r\nHost: listing\r\nConnection: close\r\n\r\n")
+		if resp.Header.Get("Content-Type") != contentTypeHTML {
+			t.Fatalf("Content-Type got: %q", resp.Header.Get("Content-Type"))
+		}
+		for _, want := range []string{`<a href="sub/">sub/</a>`, `<a href="a%20%3Cb%3E&amp;c.txt">a &lt;b&gt;&amp;c.txt</a>`, `<a href="../">`} {
+			if !strings.Contains(body, want) {
+				t.Fatalf("listing does not contain %q:\n%s", want, body)
+			}
+		}
+		if strings.Contains(body, ".hidden.txt") {
+			t.Fatal("listing shows a dot file")
+		}
+	})
+}
+
+func TestErrorStatus(t *testing.T) {
+	docRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docRoot, "secret.txt"), []byte("secret"), 0000); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: &FileServer{VirtualHosts: map[string]string{"test": docRoot}}}
+
+	t.Run("method not allowed", func(t *testing.T) {
+		resp, _ := serveOne(t, s, "DELETE /secret.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if resp.StatusCode != 405 {
+			t.Fatalf("status code got: %v, want: 405", resp.StatusCode)
+		}
+		if got := resp.Header.Get("Allow"); got != "GET, HEAD, OPTIONS" {
+			t.Fatalf("Allow got: %q, want: %q", got, "GET, HEAD, OPTIONS")
+		}
+	})
+
+	t.Run("not implemented", func(t *testing.T) {
+		resp, _ := serveOne(t, s, "BREW /secret.txt HTTP/1.1\r\nHost: test\r\n\r\n")
+		if resp.StatusCode != 501 {
+			t.Fatalf("status code got: %v, want: 501", resp.StatusCode)
+		}
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("file permissions do not apply to root")
+		}
+		resp, _ := serveOne(t, s, "GET /secret.txt HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if resp.StatusCode != 403 {
+			t.Fatalf("status code got: %v, want: 403", resp.StatusCode)
+		}
+	})
+}
+
+func TestErrorPages(t *testing.T) {
+	docRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(docRoot, "errors"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	custom := "<h1>nothing here</h1>\n"
+	if err := os.WriteFile(filepath.Join(docRoot, "errors", "404.html"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "virtual_hosts.yaml")
+	config := "virtual_hosts:\n" +
+		"  - hostName: \"test\"\n" +
+		"    docRoot: \"" + filepath.Base(docRoot) + "\"\n" +
+		"    errorPages:\n" +
+		"      404: /errors/404.html\n" +
+		"      405: /errors/missing.html\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hostOptions, err := ParseVHOptionsFile(configPath, filepath.Dir(docRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: &FileServer{
+		VirtualHosts: map[string]string{"test": docRoot, "plain": docRoot},
+		HostOptions:  hostOptions,
+	}}
+
+	var tests = []struct {
+		name            string
+		req             string
+		statusWant      int
+		contentTypeWant string
+		bodyWant        string
+	}{
+		{
+			"custom page",
+			"GET /nope.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			404,
+			contentTypeHTML,
+			custom,
+		},
+		{
+			"missing custom page falls back",
+			"DELETE /nope.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			405,
+			contentTypeHTML,
+			defaultErrorPage(405),
+		},
+		{
+			"host without pages",
+			"GET /nope.html HTTP/1.1\r\nHost: plain\r\nConnection: close\r\n\r\n",
+			404,
+			contentTypeHTML,
+			defaultErrorPage(404),
+		},
+		{
+			"unparseable request",
+			"GET /nope.html HTTP/1.1\r\n\r\n",
+			400,
+			contentTypeHTML,
+			defaultErrorPage(400),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serveOne(t, s, tt.req)
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.contentTypeWant {
+				t.Fatalf("Content-Type got: %q, want: %q", got, tt.contentTypeWant)
+			}
+			if resp.ContentLength != int64(len(tt.bodyWant)) {
+				t.Fatalf("Content-Length got: %v, want: %v", resp.ContentLength, len(tt.bodyWant))
+			}
+			if body != tt.bodyWant {
+				t.Fatalf("body got: %q, want: %q", body, tt.bodyWant)
+			}
+		})
+	}
+
+	if page := defaultErrorPage(404); !strings.Contains(page, "<title>404 Not Found</title>") {
+		t.Fatalf("default page does not name the status:\n%s", page)
+	}
+}
+
+func TestRequestTargets(t *testing.T) {
+	docRoot := t.TempDir()
+	for name, content := range map[string]string{
+		"kitten photo.txt": "kitten",
+		"a dir/index.html": "index",
+		"a%2Fb.txt":        "literal",
+		"café/menu.txt":    "menu",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(docRoot, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{Handler: &FileServer{
+		VirtualHosts: map[string]string{"test": docRoot},
+		HostOptions:  map[string]HostOptions{"test": {Writable: true}},
+	}}
+
+	var tests = []struct {
+		name       string
+		req        string
+		statusWant int
+		bodyWant   string
+	}{
+		{"encoded space", "GET /kitten%20photo.txt HTTP/1.1\r\nHost: test\r\n", 200, "kitten"},
+		{"with query", "GET /kitten%20photo.txt?v=2 HTTP/1.1\r\nHost: test\r\n", 200, "kitten"},
+		{"encoded utf-8", "GET /caf%C3%A9/menu.txt HTTP/1.1\r\nHost: test\r\n", 200, "menu"},
+		{"encoded percent", "GET /a%252Fb.txt HTTP/1.1\r\nHost: test\r\n", 200, "literal"},
+		{"index of encoded directory", "GET /a%20dir/?x=1 HTTP/1.1\r\nHost: test\r\n", 200, "index"},
+		{"absolute form", "GET http://test/kitten%20photo.txt HTTP/1.1\r\nHost: elsewhere\r\n", 200, "kitten"},
+		{"encoded slash", "GET /a%2Fb.txt HTTP/1.1\r\nHost: test\r\n", 400, ""},
+		{"encoded dot segments", "GET /%2E%2E/%2E%2E/etc/passwd HTTP/1.1\r\nHost: test\r\n", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serveOne(t, s, tt.req+"Connection: close\r\n\r\n")
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
+			}
+			if tt.bodyWant != "" && body != tt.bodyWant {
+				t.Fatalf("body got: %q, want: %q", body, tt.bodyWant)
+			}
+		})
+	}
+
+	t.Run("directory redirect keeps the encoding", func(t *testing.T) {
+		resp, _ := serveOne(t, s, "GET /a%20dir?x=1 HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if resp.StatusCode != 301 || resp.Header.Get("Location") != "/a%20dir/?x=1" {
+			t.Fatalf("got: %v to %q, want: 301 to %q", resp.StatusCode, resp.Header.Get("Location"), "/a%20dir/?x=1")
+		}
+	})
+
+	t.Run("options asterisk", func(t *testing.T) {
+		resp, _ := serveOne(t, s, "OPTIONS * HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if resp.StatusCode != 200 {
+			t.Fatalf("status code got: %v, want: 200", resp.StatusCode)
+		}
+		allowWant := "GET, HEAD, PUT, DELETE, OPTIONS"
+		if got := resp.Header.Get("Allow"); got != allowWant {
+			t.Fatalf("Allow got: %q, want: %q", got, allowWant)
+		}
+		if resp.Header.Get("DAV") != "" {
+			t.Fatal("DAV header on a host without webdav")
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package tritonhttp
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Handler responds to a request.
+//
+// ServeTriton should set headers with w.Header(), then call
+// w.WriteHeader and/or w.Write. Returning signals that the request is
+// finished; a handler that writes nothing produces an empty 200 response.
+type Handler interface {
+	ServeTriton(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeTriton calls f(w, r).
+func (f HandlerFunc) ServeTriton(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// A ResponseWriter is used by a Handler to construct a response.
+type ResponseWriter interface {
+	// Header returns the headers that will be sent by WriteHeader.
+	// Changing it after WriteHeader has no effect.
+	Header() map[string]string
+
+	// WriteHeader sends the status line and headers. Only the first
+	// call has an effect. A "Date" header is added if missing. If no
+	// "Content-Length" is set, the body is sent chunked; trailers named
+	// in a "Trailer" header are sent from Header() after the body.
+	WriteHeader(statusCode int)
+
+	// Write writes body data, calling WriteHeader(200) first if needed.
+	Write(p []byte) (int, error)
+}
+
+// responseWriter is the ResponseWriter used by Server for a single
+// request on a connection.
+type responseWriter struct {
+	w   io.Writer
+	req *Request
+
+	header      map[string]string
+	wroteHeader bool
+	status      int
+
+	// chunked is set when the body length was unknown at WriteHeader
+	// time, so the body is sent with chunked transfer-encoding
+	chunked *chunkedWriter
+
+	// closeAfter is set when the connection must be closed once the
+	// response is finished
+	closeAfter bool
+}
+
+func newResponseWriter(w io.Writer, req *Request) *responseWriter {
+	return &responseWriter{
+		w:          w,
+		req:        req,
+		header:     make(map[string]string),
+		closeAfter: req.Close,
+	}
+}
+
+func (rw *responseWriter) Header() map[string]string {
+	return rw.header
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = statusCode
+
+	if _, ok := rw.header["Date"]; !ok {
+		rw.header["Date"] = FormatTime(time.Now())
+	}
+	if rw.header["Connection"] == "close" {
+		rw.closeAfter = true
+	}
+	// a client still holding back its body is not asked for it now,
+	// so whatever it sends next cannot be told apart from a request
+	if b, ok := rw.req.Body.(*body); ok && b.expectContinue {
+		rw.closeAfter = true
+	}
+	// without a Content-Length the body is framed as chunks, which
+	// keeps the connection reusable
+	if _, ok := rw.header["Content-Length"]; !ok && bodyAllowed(statusCode, rw.req) {
+		rw.header["Transfer-Encoding"] = "chunked"
+		rw.chunked = &chunkedWriter{w: rw.w}
+	}
+	if rw.closeAfter {
+		rw.header["Connection"] = "close"
+	}
+
+	res := &Response{
+		Proto:      "HTTP/1.1",
+		StatusCode: statusCode,
+		StatusText: StatusText(statusCode),
+		Headers:    rw.header,
+	}
+	res.WriteResponse(rw.w)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(200)
+	}
+	if !bodyAllowed(rw.status, rw.req) {
+		return len(p), nil
+	}
+	if rw.chunked != nil {
+		return rw.chunked.Write(p)
+	}
+	return rw.w.Write(p)
+}
+
+// ReadFrom lets io.Copy hand the source straight to the connection,
+// so file bodies keep using sendfile.
+func (rw *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(200)
+	}
+	if !bodyAllowed(rw.status, rw.req) {
+		return io.Copy(io.Discard, r)
+	}
+	if rw.chunked != nil {
+		return io.Copy(rw.chunked, r)
+	}
+	return io.Copy(rw.w, r)
+}
+
+// finish completes the response: it sends an empty 200 if the handler
+// wrote nothing, and ends a chunked body with the trailers announced
+// in the "Trailer" header, whose values are taken from Header().
+func (rw *responseWriter) finish() {
+	if !rw.wroteHeader {
+		if _, ok := rw.header["Content-Length"]; !ok {
+			rw.header["Content-Length"] = "0"
+		}
+		rw.WriteHeader(200)
+	}
+	if rw.chunked != nil {
+		trailers := make(map[string]string)
+		for _, key := range strings.Split(rw.header["Trailer"], ",") {
+			key = CanonicalHeaderKey(strings.TrimSpace(key))
+			if value, ok := rw.header[key]; ok && key != "" {
+				trailers[key] = value
+			}
+		}
+		rw.chunked.close(trailers)
+	}
+}
+
+// bodyAllowed reports whether a response with the given status to req
+// may carry a body.
+func bodyAllowed(statusCode int, req *Request) bool {
+	if req != nil && req.Method == "HEAD" {
+		return false
+	}
+	return statusCode != 204 && statusCode != 304 && (statusCode < 100 || statusCode > 199)
+}
+
+// serveResponse sends a prepared Response through w, streaming its body.
+func serveResponse(w ResponseWriter, res *Response) {
+	for key, value := range res.Headers {
+		w.Header()[key] = value
+	}
+	if len(res.Trailers) > 0 {
+		w.Header()["Trailer"] = res.trailerNames()
+	}
+	w.WriteHeader(res.StatusCode)
+	if res.isHead() {
+		if closer, ok := res.Body.(io.Closer); ok {
+			closer.Close()
+		}
+		return
+	}
+	res.writeContent(w)
+	// trailer values may only be known once the body has been read
+	for key, value := range res.Trailers {
+		w.Header()[key] = value
+	}
+}
+
+// Error replies to the request with the given status code and a short
+// plain-text body.
+func Error(w ResponseWriter, statusCode int) {
+	body := strconv.Itoa(statusCode) + " " + StatusText(statusCode) + "\n"
+	w.Header()["Content-Type"] = "text/plain; charset=utf-8"
+	w.Header()["Content-Length"] = strconv.Itoa(len(body))
+	w.WriteHeader(statusCode)
+	io.WriteString(w, body)
+}
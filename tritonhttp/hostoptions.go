@@ -0,0 +1,86 @@
+package tritonhttp
+
+import "strings"
+
+// defaultIndexFiles are the index files looked for in a directory when
+// a virtual host does not list its own.
+var defaultIndexFiles = []string{"index.html", "index.htm"}
+
+// HostOptions holds the settings of a single virtual host, as read from
+// its entry in virtual_hosts.yaml.
+type HostOptions struct {
+	// IndexFiles lists the file names tried, in order, when a
+	// directory is requested. If empty, defaultIndexFiles is used.
+	IndexFiles []string `yaml:"indexFiles"`
+
+	// AutoIndex enables generated listings of directories that have
+	// no index file. Without it, such directories are not found.
+	AutoIndex bool `yaml:"autoIndex"`
+
+	// ErrorPages maps status codes to pages inside the docRoot, such
+	// as 404: /errors/404.html, sent as the body of those errors.
+	// Other errors get a built-in page.
+	ErrorPages map[int]string `yaml:"errorPages"`
+
+	// Writable lets PUT create or replace files and DELETE remove
+	// them. WritablePaths, if not empty, limits this to the URL paths
+	// under the listed prefixes, such as /uploads.
+	Writable      bool     `yaml:"writable"`
+	WritablePaths []string `yaml:"writablePaths"`
+
+	// Upload optionally sets up an endpoint that stores the files of
+	// HTML forms POSTed to it.
+	Upload *UploadOptions `yaml:"upload"`
+
+	// WebDAV enables the WebDAV methods, so the docRoot can be mounted
+	// as a network drive. Those that change it are only allowed where
+	// PUT and DELETE are.
+	WebDAV bool `yaml:"webdav"`
+}
+
+func (o HostOptions) indexFiles() []string {
+	if len(o.IndexFiles) == 0 {
+		return defaultIndexFiles
+	}
+	return o.IndexFiles
+}
+
+// canWrite reports whether PUT and DELETE are allowed on urlPath, which
+// must be clean and start with a slash.
+func (o HostOptions) canWrite(urlPath string) bool {
+	if !o.Writable {
+		return false
+	}
+	if len(o.WritablePaths) == 0 {
+		return true
+	}
+	for _, prefix := range o.WritablePaths {
+		// "/uploads" covers "/uploads" and everything below it, but
+		// not "/uploads2"
+		prefix = strings.TrimSuffix(prefix, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMethods lists the methods supported on some path of a virtual
+// host with these options.
+func (o HostOptions) hostMethods() string {
+	methods := []string{"GET", "HEAD"}
+	if o.Upload != nil {
+		methods = append(methods, "POST")
+	}
+	if o.Writable {
+		methods = append(methods, "PUT", "DELETE")
+	}
+	methods = append(methods, "OPTIONS")
+	if o.WebDAV {
+		methods = append(methods, "PROPFIND", "COPY")
+		if o.Writable {
+			methods = append(methods, "MOVE", "MKCOL")
+		}
+	}
+	return strings.Join(methods, ", ")
+}
@@ -0,0 +1,95 @@
+package tritonhttp
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// HostTable resolves the Host header of a request to the name of a
+// virtual host. Matching ignores case, a trailing dot and any port. A
+// host is matched by its name or one of its aliases first; failing
+// that, by the longest wildcard pattern, such as "*.example.test",
+// that covers it; and failing that, it goes to the default host.
+type HostTable struct {
+	exact       map[string]string // normalized name or alias -> host name
+	wildcards   []hostPattern     // longest suffix first
+	defaultHost string
+}
+
+// hostPattern is a wildcard name or alias, "*" followed by suffix.
+type hostPattern struct {
+	suffix string // e.g. ".example.test"
+	name   string
+}
+
+// NewHostTable builds a HostTable for the given host names, which may
+// be wildcard patterns. aliases lists further names, or patterns, for
+// some of the hosts, and defaultHost, if not empty, receives requests
+// that match no host.
+func NewHostTable(names []string, aliases map[string][]string, defaultHost string) *HostTable {
+	t := &HostTable{exact: make(map[string]string), defaultHost: defaultHost}
+	add := func(pattern, name string) {
+		pattern = normalizeHost(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			t.wildcards = append(t.wildcards, hostPattern{suffix: pattern[1:], name: name})
+		} else {
+			t.exact[pattern] = name
+		}
+	}
+	for _, name := range names {
+		add(name, name)
+	}
+	for name, list := range aliases {
+		for _, alias := range list {
+			add(alias, name)
+		}
+	}
+	sort.SliceStable(t.wildcards, func(i, j int) bool {
+		return len(t.wildcards[i].suffix) > len(t.wildcards[j].suffix)
+	})
+	return t
+}
+
+// hostTableFor builds a HostTable matching the keys of virtualHosts.
+func hostTableFor(virtualHosts map[string]string) *HostTable {
+	names := make([]string, 0, len(virtualHosts))
+	for name := range virtualHosts {
+		names = append(names, name)
+	}
+	return NewHostTable(names, nil, "")
+}
+
+// Match returns the host that host names, either directly, through an
+// alias, or through a wildcard pattern. It does not fall back to the
+// default host.
+func (t *HostTable) Match(host string) (string, bool) {
+	host = normalizeHost(host)
+	if name, ok := t.exact[host]; ok {
+		return name, true
+	}
+	for _, p := range t.wildcards {
+		// the wildcard stands for at least one label
+		if len(host) > len(p.suffix) && strings.HasSuffix(host, p.suffix) {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+// Lookup is like Match, but falls back to the default host. It returns
+// "" if nothing matches and there is no default host.
+func (t *HostTable) Lookup(host string) string {
+	if name, ok := t.Match(host); ok {
+		return name
+	}
+	return t.defaultHost
+}
+
+// normalizeHost lowercases host and strips its port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
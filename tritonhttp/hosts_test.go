@@ -0,0 +1,93 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostTable(t *testing.T) {
+	hosts := NewHostTable(
+		[]string{"website1", "*.example.test", "*.api.example.test", "Mixed.Case"},
+		map[string][]string{"website1": {"www.website1", "*.website1.test"}},
+		"website1",
+	)
+	var tests = []struct {
+		host      string
+		nameWant  string
+		matchWant bool
+	}{
+		{"website1", "website1", true},
+		{"WEBSITE1", "website1", true},
+		{"website1:8080", "website1", true},
+		{"website1.", "website1", true},
+		{"www.website1", "website1", true},
+		{"a.website1.test", "website1", true},
+		{"mixed.case", "Mixed.Case", true},
+		{"shop.example.test", "*.example.test", true},
+		{"a.b.example.test:443", "*.example.test", true},
+		{"v1.api.example.test", "*.api.example.test", true},
+		{"example.test", "website1", false},
+		{"unknown", "website1", false},
+		{"[::1]:8080", "website1", false},
+	}
+	for _, tt := range tests {
+		name, ok := hosts.Match(tt.host)
+		if ok != tt.matchWant || (ok && name != tt.nameWant) {
+			t.Fatalf("Match(%q) got: %q, %v, want: %q, %v", tt.host, name, ok, tt.nameWant, tt.matchWant)
+		}
+		if got := hosts.Lookup(tt.host); got != tt.nameWant {
+			t.Fatalf("Lookup(%q) got: %q, want: %q", tt.host, got, tt.nameWant)
+		}
+	}
+
+	if got := NewHostTable([]string{"website1"}, nil, "").Lookup("unknown"); got != "" {
+		t.Fatalf("Lookup without default host got: %q, want: \"\"", got)
+	}
+}
+
+func TestFileServerHosts(t *testing.T) {
+	docrootDirs := t.TempDir()
+	for _, host := range []string{"main", "wild"} {
+		if err := os.Mkdir(filepath.Join(docrootDirs, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(docrootDirs, host, "index.html"), []byte(host), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := LoadConfig(writeTestConfig(t, "defaultHost: \"main\"\n"+
+		"virtual_hosts:\n"+
+		"  - hostName: \"main\"\n"+
+		"    docRoot: \"main\"\n"+
+		"    aliases: [\"www.main\"]\n"+
+		"  - hostName: \"*.wild.test\"\n"+
+		"    docRoot: \"wild\"\n"), docrootDirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{VirtualHosts: config.VirtualHostMap(), Hosts: config.HostTable()}
+
+	for host, bodyWant := range map[string]string{
+		"MAIN:8080":    "main",
+		"www.main":     "main",
+		"a.wild.test":  "wild",
+		"unknown.host": "main",
+	} {
+		resp, body := serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n")
+		if resp.StatusCode != 200 || body != bodyWant {
+			t.Fatalf("Host %q got: %v %q, want: 200 %q", host, resp.StatusCode, body, bodyWant)
+		}
+	}
+
+	// without a HostTable, case and port are still ignored
+	s = &Server{VirtualHosts: config.VirtualHostMap()}
+	resp, _ := serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: Main:8080\r\nConnection: close\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status code got: %v, want: 200", resp.StatusCode)
+	}
+	resp, _ = serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: unknown.host\r\nConnection: close\r\n\r\n")
+	if resp.StatusCode != 404 {
+		t.Fatalf("status code got: %v, want: 404", resp.StatusCode)
+	}
+}
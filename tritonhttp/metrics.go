@@ -0,0 +1,201 @@
+package tritonhttp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket upper bounds of the latency and response size histograms.
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets    = []float64{100, 1000, 10000, 100000, 1e6, 1e7, 1e8}
+)
+
+// Metrics collects counters about the servers that share it, and
+// serves them at /metrics in the Prometheus text exposition format.
+// Set it as Server.Metrics on every server to watch.
+type Metrics struct {
+	mu          sync.Mutex
+	servers     map[*Server]struct{}
+	requests    map[requestLabels]uint64
+	latency     *histogram
+	size        *histogram
+	timeouts    uint64
+	timeout400s uint64
+}
+
+// requestLabels identifies one series of tritonhttp_requests_total.
+type requestLabels struct {
+	host   string
+	method string
+	status int
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] observations are <= bounds[i]
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		servers:  make(map[*Server]struct{}),
+		requests: make(map[requestLabels]uint64),
+		latency:  newHistogram(latencyBuckets),
+		size:     newHistogram(sizeBuckets),
+	}
+}
+
+// trackServer adds s to the servers whose connections are reported.
+func (m *Metrics) trackServer(s *Server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers[s] = struct{}{}
+}
+
+// observeRequest records one response with its body size and how long
+// it took to serve.
+func (m *Metrics) observeRequest(host, method string, status int, size int64, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{host, method, status}]++
+	m.latency.observe(latency.Seconds())
+	m.size.observe(float64(size))
+}
+
+// observeTimeout records a connection whose read deadline passed;
+// partial reports whether part of a request had been read, so it was
+// answered with a 400.
+func (m *Metrics) observeTimeout(partial bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeouts++
+	if partial {
+		m.timeout400s++
+	}
+}
+
+// ServeTriton serves the metrics at /metrics; any other path is not
+// found.
+func (m *Metrics) ServeTriton(w ResponseWriter, r *Request) {
+	if urlPath, _ := r.target(); urlPath != "/metrics" {
+		Error(w, 404)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header()["Allow"] = "GET, HEAD"
+		Error(w, 405)
+		return
+	}
+	var b strings.Builder
+	m.WriteText(&b)
+	w.Header()["Content-Type"] = "text/plain; version=0.0.4; charset=utf-8"
+	w.Header()["Content-Length"] = strconv.Itoa(b.Len())
+	w.WriteHeader(200)
+	if r.Method != "HEAD" {
+		io.WriteString(w, b.String())
+	}
+}
+
+// WriteText writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	// read the connection gauges first, so m.mu and Server.mu are
+	// never held together
+	m.mu.Lock()
+	servers := make([]*Server, 0, len(m.servers))
+	for s := range m.servers {
+		servers = append(servers, s)
+	}
+	m.mu.Unlock()
+	var open, idle int
+	for _, s := range servers {
+		o, i := s.connCounts()
+		open += o
+		idle += i
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	writeHeader(&b, "tritonhttp_requests_total", "counter", "Requests served, by virtual host, method and status.")
+	keys := make([]requestLabels, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "tritonhttp_requests_total{host=%s,method=%s,status=\"%d\"} %d\n",
+			labelValue(key.host), labelValue(key.method), key.status, m.requests[key])
+	}
+
+	writeHeader(&b, "tritonhttp_request_duration_seconds", "histogram", "Time taken to serve a request.")
+	writeHistogram(&b, "tritonhttp_request_duration_seconds", m.latency)
+	writeHeader(&b, "tritonhttp_response_size_bytes", "histogram", "Size of response bodies.")
+	writeHistogram(&b, "tritonhttp_response_size_bytes", m.size)
+
+	writeHeader(&b, "tritonhttp_open_connections", "gauge", "Connections currently open.")
+	fmt.Fprintf(&b, "tritonhttp_open_connections %d\n", open)
+	writeHeader(&b, "tritonhttp_idle_connections", "gauge", "Keep-alive connections waiting for their next request.")
+	fmt.Fprintf(&b, "tritonhttp_idle_connections %d\n", idle)
+
+	writeHeader(&b, "tritonhttp_timeouts_total", "counter", "Connections closed because no complete request arrived within the idle or read header timeout.")
+	fmt.Fprintf(&b, "tritonhttp_timeouts_total %d\n", m.timeouts)
+	writeHeader(&b, "tritonhttp_timeout_bad_requests_total", "counter", "400 responses sent for partial requests cut off by the read header timeout.")
+	fmt.Fprintf(&b, "tritonhttp_timeout_bad_requests_total %d\n", m.timeout400s)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(b *strings.Builder, name string, h *histogram) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(b, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count %d\n", name, h.count)
+}
+
+// labelValue quotes s as a label value, escaping backslashes, double
+// quotes and newlines.
+func labelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
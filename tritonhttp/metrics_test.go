@@ -0,0 +1,99 @@
+package tritonhttp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	docRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docRoot, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetrics()
+	s := &Server{VirtualHosts: map[string]string{"test": docRoot}, Metrics: m}
+	m.trackServer(s)
+
+	serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	serveOne(t, s, "GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	serveOne(t, s, "GET /missing.html HTTP/1.1\r\nHost: made-up\r\nConnection: close\r\n\r\n")
+	serveOne(t, s, "GET /index.html HTTP/1.0\r\nHost: test\r\n\r\n")
+	m.observeTimeout(false)
+	m.observeTimeout(true)
+
+	// leave one connection idle, waiting for a request
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+
+	var text string
+	for i := 0; i < 100; i++ {
+		var b strings.Builder
+		m.WriteText(&b)
+		text = b.String()
+		if strings.Contains(text, "tritonhttp_idle_connections 1\n") &&
+			strings.Contains(text, "tritonhttp_request_duration_seconds_count 4\n") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, want := range []string{
+		"# TYPE tritonhttp_requests_total counter\n",
+		`tritonhttp_requests_total{host="test",method="GET",status="200"} 2` + "\n",
+		`tritonhttp_requests_total{host="other",method="GET",status="404"} 1` + "\n",
+		`tritonhttp_requests_total{host="",method="",status="505"} 1` + "\n",
+		"# TYPE tritonhttp_request_duration_seconds histogram\n",
+		`tritonhttp_request_duration_seconds_bucket{le="+Inf"} 4` + "\n",
+		"tritonhttp_request_duration_seconds_count 4\n",
+		`tritonhttp_response_size_bytes_bucket{le="100"} 2` + "\n",
+		`tritonhttp_response_size_bytes_bucket{le="1000"} 4` + "\n",
+		"tritonhttp_response_size_bytes_sum " + strconv.Itoa(10+len(defaultErrorPage(404))+len(defaultErrorPage(505))) + "\n",
+		"tritonhttp_open_connections 1\n",
+		"tritonhttp_idle_connections 1\n",
+		"tritonhttp_timeouts_total 2\n",
+		"tritonhttp_timeout_bad_requests_total 1\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := &Server{Handler: NewMetrics()}
+	resp, body := serveOne(t, s, "GET /metrics HTTP/1.1\r\nHost: admin\r\nConnection: close\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status code got: %v, want: 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type got: %q", got)
+	}
+	if !strings.Contains(body, "tritonhttp_open_connections 0\n") {
+		t.Fatalf("body got: %q", body)
+	}
+	resp, _ = serveOne(t, s, "GET /other HTTP/1.1\r\nHost: admin\r\nConnection: close\r\n\r\n")
+	if resp.StatusCode != 404 {
+		t.Fatalf("status code got: %v, want: 404", resp.StatusCode)
+	}
+}
+
+func TestMetricsHostWithoutVirtualHosts(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	for _, host := range []string{"a.test", "b.test:8080"} {
+		if got := s.metricsHost(host); got != "other" {
+			t.Fatalf("label of %q got: %q, want: %q", host, got, "other")
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	if got, want := labelValue("a\"b\\c\nd"), `"a\"b\\c\nd"`; got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+}
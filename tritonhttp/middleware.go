@@ -0,0 +1,122 @@
+package tritonhttp
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a Handler to add behavior before and/or after it.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the given middlewares. The first middleware is the
+// outermost one, so it sees the request first and the response last.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// statusRecorder wraps a ResponseWriter to remember the status code and
+// the number of body bytes written.
+type statusRecorder struct {
+	ResponseWriter
+	status  int
+	written int64
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if sr.status == 0 {
+		sr.status = statusCode
+	}
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = 200
+	}
+	n, err := sr.ResponseWriter.Write(p)
+	sr.written += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the wrapped writer's ReadFrom, if any, reachable
+// through io.Copy.
+func (sr *statusRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if sr.status == 0 {
+		sr.status = 200
+	}
+	var n int64
+	var err error
+	if rf, ok := sr.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{sr.ResponseWriter}, r)
+	}
+	sr.written += n
+	return n, err
+}
+
+// Logging logs one line per request with its status, body size and
+// how long it took to serve.
+func Logging(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeTriton(sr, r)
+		if sr.status == 0 {
+			sr.status = 200
+		}
+		log.Printf("%s %s %s %s %d %d %v", r.Host, r.Method, r.URL, r.Proto, sr.status, sr.written, time.Since(start))
+	})
+}
+
+// BasicAuth returns a Middleware that only lets requests through when
+// they carry HTTP Basic credentials matching one of users, which maps
+// user names to passwords. Other requests get a 401 challenge for realm.
+func BasicAuth(realm string, users map[string]string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			if user, pass, ok := basicAuth(r); ok {
+				if want, found := users[user]; found && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1 {
+					next.ServeTriton(w, r)
+					return
+				}
+			}
+			w.Header()["WWW-Authenticate"] = "Basic realm=\"" + realm + "\""
+			Error(w, 401)
+		})
+	}
+}
+
+// basicAuth extracts the credentials of a Basic "Authorization" header.
+func basicAuth(r *Request) (user, pass string, ok bool) {
+	auth := r.Headers["Authorization"]
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// SetHeaders returns a Middleware that adds the given headers to every
+// response, unless the handler sets them itself.
+func SetHeaders(headers map[string]string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			for key, value := range headers {
+				w.Header()[key] = value
+			}
+			next.ServeTriton(w, r)
+		})
+	}
+}
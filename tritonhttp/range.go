@@ -0,0 +1,146 @@
+package tritonhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges caps how many ranges a single Range header may ask for,
+// so a client cannot make us emit a huge multipart body of tiny parts.
+const maxRanges = 32
+
+var (
+	errMalformedRange     = errors.New("malformed range")
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+// byteRange is one part of a file selected by a Range header.
+type byteRange struct {
+	start, length int64
+
+	// header holds the part headers written before this range in a
+	// multipart/byteranges body. It is empty for a single-range response.
+	header string
+}
+
+// contentRange formats the Content-Range value of r within a file of size bytes.
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// parseRange parses a "bytes=" Range header value against a file of size
+// bytes. Ranges that start beyond the end of the file are dropped; if none
+// remain, errUnsatisfiableRange is returned. A syntactically invalid value
+// yields errMalformedRange, in which case the header should be ignored.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errMalformedRange
+	}
+	specs := strings.Split(s[len(prefix):], ",")
+	if len(specs) > maxRanges {
+		return nil, errMalformedRange
+	}
+
+	var ranges []byteRange
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		dash := strings.IndexByte(spec, '-')
+		if dash < 0 {
+			return nil, errMalformedRange
+		}
+		first, last := spec[:dash], spec[dash+1:]
+
+		var r byteRange
+		if first == "" {
+			// suffix range: the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errMalformedRange
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errMalformedRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errMalformedRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r.start = start
+			r.length = end - start + 1
+		}
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// selectRanges decides which ranges of the file described by fi should be
+// sent for req. It returns nil ranges and a nil error when the whole file
+// should be sent: no Range header, a method other than GET, a failed
+// If-Range check, or a malformed Range value.
+func selectRanges(req *Request, fi os.FileInfo) ([]byteRange, error) {
+	rangeHeader, ok := req.Headers["Range"]
+	if !ok || req.Method != "GET" {
+		return nil, nil
+	}
+
+	if ifRange, ok := req.Headers["If-Range"]; ok {
+		if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+			if !etagStrongMatch(ifRange, generateETag(fi)) {
+				return nil, nil
+			}
+		} else {
+			t, err := ParseTime(ifRange)
+			if err != nil || !t.Equal(fi.ModTime().Truncate(time.Second)) {
+				return nil, nil
+			}
+		}
+	}
+
+	ranges, err := parseRange(rangeHeader, fi.Size())
+	if err == errMalformedRange {
+		return nil, nil
+	}
+	return ranges, err
+}
+
+// multipartBoundary returns a random boundary for a multipart/byteranges body.
+func multipartBoundary() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,138 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	var tests = []struct {
+		name    string
+		in      string
+		want    []byteRange
+		wantErr error
+	}{
+		{"first bytes", "bytes=0-4", []byteRange{{start: 0, length: 5}}, nil},
+		{"open ended", "bytes=370-", []byteRange{{start: 370, length: 7}}, nil},
+		{"suffix", "bytes=-10", []byteRange{{start: 367, length: 10}}, nil},
+		{"suffix larger than file", "bytes=-1000", []byteRange{{start: 0, length: 377}}, nil},
+		{"end clamped", "bytes=300-999", []byteRange{{start: 300, length: 77}}, nil},
+		{"multiple", "bytes=0-0, -1", []byteRange{{start: 0, length: 1}, {start: 376, length: 1}}, nil},
+		{"unsatisfiable", "bytes=377-", nil, errUnsatisfiableRange},
+		{"wrong unit", "items=0-1", nil, errMalformedRange},
+		{"reversed", "bytes=5-1", nil, errMalformedRange},
+		{"garbage", "bytes=a-b", nil, errMalformedRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.in, 377)
+			if err != tt.wantErr {
+				t.Fatalf("error got: %v, want: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectRanges(t *testing.T) {
+	fi, err := os.Stat("../docroot_dirs/htdocs1/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := generateETag(fi)
+
+	var tests = []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int // number of ranges, -1 for a 416
+	}{
+		{"no range", "GET", map[string]string{}, 0},
+		{"head ignores range", "HEAD", map[string]string{"Range": "bytes=0-1"}, 0},
+		{"if-range etag match", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": etag}, 1},
+		{"if-range etag mismatch", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`}, 0},
+		{"if-range weak etag", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": weakETag(etag)}, 0},
+		{"if-range date match", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": FormatTime(fi.ModTime())}, 1},
+		{"malformed is ignored", "GET", map[string]string{"Range": "bytes=x"}, 0},
+		{"unsatisfiable", "GET", map[string]string{"Range": "bytes=1000-"}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := selectRanges(&Request{Method: tt.method, Headers: tt.headers}, fi)
+			got := len(ranges)
+			if err == errUnsatisfiableRange {
+				got = -1
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipartByteranges(t *testing.T) {
+	fs := &FileServer{
+		VirtualHosts: ParseVHConfigFile("../virtual_hosts.yaml", "../docroot_dirs"),
+	}
+	req := &Request{Method: "GET", URL: "/index.html", Proto: "HTTP/1.1", Headers: map[string]string{}, Host: "website1"}
+	ranges, err := parseRange("bytes=0-5,-6", 377)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	absolutePath := fs.localPath(req)
+	fi, err := os.Stat(absolutePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.handle206Requests(req, absolutePath, fi, ranges).WriteResponse(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&buffer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(body)) != resp.ContentLength {
+		t.Fatalf("body length got: %v, want Content-Length: %v", len(body), resp.ContentLength)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("unexpected Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+	original, err := os.ReadFile("../docroot_dirs/htdocs1/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wants := [][]byte{original[:6], original[371:]}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for i, want := range wants {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		got, _ := io.ReadAll(part)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("part %d got: %q, want: %q", i, got, want)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("expected exactly %d parts, got error %v", len(wants), err)
+	}
+}
@@ -0,0 +1,158 @@
+package tritonhttp
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader is a Handler that serves every request with the handler
+// built from the current config. Reload builds a new handler and swaps
+// it in atomically: requests already being served finish with the old
+// one, and open connections are kept. If building fails, the old
+// handler stays in place.
+type Reloader struct {
+	load func() (Handler, error)
+
+	handler atomic.Value // *reloadedHandler
+
+	mu     sync.Mutex // serializes reloads and guards status
+	status ReloadStatus
+}
+
+// reloadedHandler wraps the current handler, as atomic.Value needs all
+// stored values to have the same concrete type.
+type reloadedHandler struct {
+	Handler
+}
+
+// ReloadStatus describes the outcome of the latest reload.
+type ReloadStatus struct {
+	Time     time.Time `json:"time"`
+	Trigger  string    `json:"trigger"`
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Reloads  int       `json:"reloads"`
+	Failures int       `json:"failures"`
+}
+
+// NewReloader builds the first handler with load, which is expected to
+// read and fully validate the config before building the handler for
+// it. load is called again by every Reload.
+func NewReloader(load func() (Handler, error)) (*Reloader, error) {
+	h, err := load()
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{load: load}
+	r.handler.Store(&reloadedHandler{h})
+	r.status = ReloadStatus{Time: time.Now(), Trigger: "startup", OK: true}
+	return r, nil
+}
+
+func (r *Reloader) ServeTriton(w ResponseWriter, req *Request) {
+	r.handler.Load().(*reloadedHandler).ServeTriton(w, req)
+}
+
+// HostLookup resolves the Host header of a request to the name of a
+// virtual host, or "" if there is none. *HostTable, *FileServer and
+// *Reloader implement it.
+type HostLookup interface {
+	Lookup(host string) string
+}
+
+// Lookup resolves host like the current handler does, if it implements
+// HostLookup, so hosts added or removed by a reload are seen at once.
+// Otherwise it returns "".
+func (r *Reloader) Lookup(host string) string {
+	if hl, ok := r.handler.Load().(*reloadedHandler).Handler.(HostLookup); ok {
+		return hl.Lookup(host)
+	}
+	return ""
+}
+
+// Reload builds a new handler and swaps it in, or keeps the old one if
+// that fails. trigger says what asked for the reload, e.g. "SIGHUP",
+// and is logged and reported in the status.
+func (r *Reloader) Reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Time = time.Now()
+	r.status.Trigger = trigger
+	h, err := r.load()
+	if err != nil {
+		r.status.OK = false
+		r.status.Error = err.Error()
+		r.status.Failures++
+		log.Printf("Reload (%s) failed, keeping the old config: %v", trigger, err)
+		return err
+	}
+	r.handler.Store(&reloadedHandler{h})
+	r.status.OK = true
+	r.status.Error = ""
+	r.status.Reloads++
+	log.Printf("Reload (%s) succeeded", trigger)
+	return nil
+}
+
+// Status returns the outcome of the latest reload.
+func (r *Reloader) Status() ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Watch polls the file at path every interval and reloads when its
+// size or modification time changes, until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, path string, interval time.Duration) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last != nil && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+			continue
+		}
+		last = fi
+		r.Reload("file change")
+	}
+}
+
+// ServeStatus is a HandlerFunc for an admin endpoint: GET reports the
+// outcome of the latest reload as JSON, and POST reloads first. A
+// failed reload is answered with a 500.
+func (r *Reloader) ServeStatus(w ResponseWriter, req *Request) {
+	statusCode := 200
+	switch req.Method {
+	case "GET", "HEAD":
+	case "POST":
+		if err := r.Reload("admin"); err != nil {
+			statusCode = 500
+		}
+	default:
+		w.Header()["Allow"] = "GET, HEAD, POST"
+		Error(w, 405)
+		return
+	}
+	body, _ := json.Marshal(r.Status())
+	body = append(body, '\n')
+	w.Header()["Content-Type"] = "application/json"
+	w.Header()["Content-Length"] = strconv.Itoa(len(body))
+	w.WriteHeader(statusCode)
+	if req.Method != "HEAD" {
+		w.Write(body)
+	}
+}
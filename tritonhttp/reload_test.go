@@ -0,0 +1,147 @@
+package tritonhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	docrootDirs := t.TempDir()
+	for _, host := range []string{"one", "two"} {
+		if err := os.Mkdir(filepath.Join(docrootDirs, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(docrootDirs, host, "index.html"), []byte(host), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configPath := filepath.Join(t.TempDir(), "virtual_hosts.yaml")
+	writeConfig := func(hosts ...string) {
+		t.Helper()
+		config := "virtual_hosts:\n"
+		for _, host := range hosts {
+			config += "  - hostName: \"" + host + "\"\n    docRoot: \"" + host + "\"\n"
+		}
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("one")
+
+	r, err := NewReloader(func() (Handler, error) {
+		virtualHosts, err := LoadVirtualHosts(configPath, docrootDirs)
+		if err != nil {
+			return nil, err
+		}
+		return &FileServer{VirtualHosts: virtualHosts}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetrics()
+	s := &Server{Handler: r, MetricsHosts: r, Metrics: m}
+
+	// one keep-alive connection lives through all reloads
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	get := func(host string) int {
+		t.Helper()
+		return serveOneOn(t, client, "GET /index.html HTTP/1.1\r\nHost: "+host+"\r\n\r\n").StatusCode
+	}
+
+	if got := get("two"); got != 404 {
+		t.Fatalf("status code before reload got: %v, want: 404", got)
+	}
+	writeConfig("one", "two")
+	if err := r.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("two"); got != 200 {
+		t.Fatalf("status code after reload got: %v, want: 200", got)
+	}
+
+	// a config with a missing docRoot is rejected and the old one kept
+	writeConfig("one", "two", "three")
+	if err := r.Reload("test"); err == nil {
+		t.Fatal("reload with a missing docRoot succeeded")
+	}
+	if got := get("two"); got != 200 {
+		t.Fatalf("status code after failed reload got: %v, want: 200", got)
+	}
+	status := r.Status()
+	if status.OK || status.Error == "" || status.Reloads != 1 || status.Failures != 1 {
+		t.Fatalf("status got: %+v", status)
+	}
+
+	// the admin endpoint reports, and can trigger, reloads
+	writeConfig("one")
+	admin := &Server{Handler: HandlerFunc(r.ServeStatus)}
+	resp, body := serveOne(t, admin, "POST /reload HTTP/1.1\r\nHost: admin\r\nConnection: close\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Fatalf("admin status code got: %v, want: 200", resp.StatusCode)
+	}
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.OK || status.Trigger != "admin" || status.Reloads != 2 {
+		t.Fatalf("admin status got: %+v", status)
+	}
+	if got := get("two"); got != 404 {
+		t.Fatalf("status code after removing host got: %v, want: 404", got)
+	}
+
+	// metrics name hosts by the config of the time
+	want := `tritonhttp_requests_total{host="two",method="GET",status="200"} 2` + "\n"
+	var text string
+	for i := 0; i < 100; i++ {
+		var b strings.Builder
+		m.WriteText(&b)
+		text = b.String()
+		if strings.Contains(text, `host="other",method="GET",status="404"} 2`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(text, want) {
+		t.Fatalf("metrics do not contain %q:\n%s", want, text)
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configPath, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loads := make(chan struct{}, 10)
+	r, err := NewReloader(func() (Handler, error) {
+		loads <- struct{}{}
+		return HandlerFunc(func(w ResponseWriter, r *Request) {}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-loads
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, configPath, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(configPath, []byte("ab"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-loads:
+	case <-time.After(time.Second):
+		t.Fatal("no reload after the config file changed")
+	}
+	if status := r.Status(); status.Trigger != "file change" {
+		t.Fatalf("trigger got: %q, want: %q", status.Trigger, "file change")
+	}
+}
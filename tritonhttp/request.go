@@ -2,90 +2,192 @@ package tritonhttp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
 type Request struct {
-	Method string // e.g. "GET"
-	URL    string // e.g. "/path/to/a/file"
+	Method string // e.g. "GET" or "HEAD"
+	URL    string // e.g. "/path/to/a%20file?v=2", or "*"
 	Proto  string // e.g. "HTTP/1.1"
 
-	// Headers stores the key-value HTTP headers
+	// Path is the path of URL, percent-decoded, e.g. "/path/to/a file".
+	// RawQuery is the query after the "?", still encoded, and Query
+	// holds its values, or is nil if there is no query.
+	Path     string
+	RawQuery string
+	Query    url.Values
+
+	// Headers stores the key-value HTTP headers, with keys in canonical form
 	Headers map[string]string
 
 	Host  string // determine from the "Host" header
 	Close bool   // determine from the "Connection" header
+
+	// Body is the request body, or nil if the request has none. It
+	// ends after Content-Length bytes, or, for a "Transfer-Encoding:
+	// chunked" body, is decoded while it is read. The Server reads
+	// whatever the handler leaves unread before the next request.
+	Body io.ReadCloser
+
+	// Trailer holds the trailer fields of a chunked body. It is filled
+	// in once Body has been read to EOF.
+	Trailer map[string]string
+
+	// RemoteAddr is the network address of the client, set by the
+	// Server; ReadRequest leaves it empty.
+	RemoteAddr string
+}
+
+// knownMethods lists the request methods defined by RFC 9110, RFC 5789
+// and, for WebDAV, RFC 4918. A known method reaches the Handler, which
+// may still refuse it with a 405; any other method is rejected with a
+// 501.
+var knownMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+
+	"PROPFIND":  true,
+	"PROPPATCH": true,
+	"MKCOL":     true,
+	"COPY":      true,
+	"MOVE":      true,
+	"LOCK":      true,
+	"UNLOCK":    true,
 }
 
+const (
+	// maxRequestLineBytes bounds the request line; a longer one is
+	// answered with 414 URI Too Long.
+	maxRequestLineBytes = 8 << 10
+
+	// DefaultMaxHeaderBytes bounds the header section unless
+	// Server.MaxHeaderBytes says otherwise; a longer one is answered
+	// with 431 Request Header Fields Too Large.
+	DefaultMaxHeaderBytes = 1 << 20
+)
+
+// ErrBodyTooLarge is returned when reading a request body beyond
+// Server.MaxBodyBytes.
+var ErrBodyTooLarge = errors.New("tritonhttp: request body too large")
+
+// ReadRequest reads and parses one request from reader. readIn reports
+// whether any of the request was read. If the request cannot be served,
+// err is a *ProtocolError carrying the status to respond with.
 func ReadRequest(reader *bufio.Reader) (req *Request, readIn bool, err error) {
+	req, readIn, err = readRequest(reader, readOptions{maxHeaderBytes: DefaultMaxHeaderBytes})
+	if err != nil {
+		return nil, readIn, err
+	}
+	return req, readIn, nil
+}
+
+// readOptions holds the limits and choices of readRequest, which the
+// Server takes from its fields of the same names.
+type readOptions struct {
+	maxHeaderBytes      int
+	maxBodyBytes        int64 // 0 for no limit
+	allowEncodedSlashes bool
+}
+
+// readRequest is ReadRequest with the given options. If the request
+// cannot be served, req still holds what was read of it, for the
+// access log.
+func readRequest(reader *bufio.Reader, opts readOptions) (req *Request, readIn bool, err error) {
+	maxHeaderBytes, maxBodyBytes := opts.maxHeaderBytes, opts.maxBodyBytes
+
 	req = &Request{}
 	req.Headers = make(map[string]string)
 
 	// read initial request line
-	request, _, err := reader.ReadLine()
-	if err != nil {
+	request, tooLong, err := readLine(reader, maxRequestLineBytes)
+	if err != nil && len(request) == 0 {
 		log.Println("read request line error: ", err)
 		return nil, false, err
 	}
+	if tooLong {
+		return req, true, &ProtocolError{StatusCode: 414, Reason: "request line too long"}
+	}
+	if err != nil {
+		log.Println("read request line error: ", err)
+		return req, true, &ProtocolError{StatusCode: 400, Reason: "incomplete request line", Err: err}
+	}
 
 	requestFields := strings.Split(string(request), " ")
 	// check for incorrect request line formats
 	// if format incorrect, return 400 error
 	if len(requestFields) != 3 {
 		log.Println("incorrect request line format")
-		req.Method = ""
-		req.URL = ""
-		req.Proto = ""
-		return nil, true, fmt.Errorf("400")
+		return req, true, badRequest("malformed request line")
 	}
 
 	req.Method = requestFields[0]
 	req.URL = requestFields[1]
 	req.Proto = requestFields[2]
 
-	if req.Method != "GET" {
-		return nil, true, fmt.Errorf("400")
+	if !isToken(req.Method) {
+		return req, true, badRequest("malformed method")
 	}
-
-	if req.URL[0] != '/' {
-		return nil, true, fmt.Errorf("400")
+	if !knownMethods[req.Method] {
+		return req, true, &ProtocolError{StatusCode: 501, Reason: "unknown method " + req.Method}
 	}
 
-	if req.Proto != "HTTP/1.1" {
-		return nil, true, fmt.Errorf("400")
+	absoluteHost, err := parseTarget(req, opts.allowEncodedSlashes)
+	if err != nil {
+		return req, true, err
 	}
 
-	if req.URL == "/" {
-		req.URL = "/index.html"
+	if !isHTTPVersion(req.Proto) {
+		return req, true, badRequest("malformed protocol version")
+	}
+	if req.Proto != "HTTP/1.1" {
+		return req, true, &ProtocolError{StatusCode: 505, Reason: "unsupported protocol version " + req.Proto}
 	}
 
 	// start reading in body of the request file
 	hostExist := false
 	req.Close = false
+	headerBytes := 0
 	for {
-		line, _, err := reader.ReadLine()
+		line, tooLong, err := readLine(reader, maxHeaderBytes-headerBytes)
+		if tooLong {
+			return req, true, &ProtocolError{StatusCode: 431, Reason: "header section too large"}
+		}
 		if err != nil {
 			log.Println("read line error: ", err)
-			return nil, true, fmt.Errorf("400")
+			return req, true, &ProtocolError{StatusCode: 400, Reason: "incomplete header section", Err: err}
 		}
+		headerBytes += len(line) + 2
 		// reached the end
-		if len(line) == 0 || err == io.EOF {
+		if len(line) == 0 {
 			break
 		}
-		lineFields := strings.Split(string(line), ": ")
-		if len(lineFields) != 2 {
-			return nil, true, fmt.Errorf("400")
+		key, value, ok := strings.Cut(string(line), ":")
+		if !ok || !isToken(key) {
+			return req, true, badRequest("malformed header line")
 		}
-		key := lineFields[0]
-		value := lineFields[1]
+		key = CanonicalHeaderKey(key)
+		value = strings.TrimSpace(value)
 
 		if key == "Host" {
+			if hostExist {
+				return req, true, badRequest("duplicate Host header")
+			}
 			hostExist = true
 			req.Host = value
-		} else if key == "Connection" && value == "close" {
+		} else if key == "Connection" && strings.EqualFold(value, "close") {
 			req.Close = true
 		} else {
 			req.Headers[key] = value
@@ -93,8 +195,421 @@ func ReadRequest(reader *bufio.Reader) (req *Request, readIn bool, err error) {
 	}
 
 	if !hostExist {
-		return nil, true, fmt.Errorf("400")
+		return req, true, badRequest("missing Host header")
+	}
+	// the host of an absolute-form target overrides the Host header
+	if absoluteHost != "" {
+		req.Host = absoluteHost
+	}
+
+	expectContinue := false
+	if expect, ok := req.Headers["Expect"]; ok {
+		if !strings.EqualFold(expect, "100-continue") {
+			return req, true, &ProtocolError{StatusCode: 417, Reason: "unsupported expectation " + expect}
+		}
+		expectContinue = true
+	}
+
+	// the body is read by the handler, framed either by chunks or by
+	// Content-Length; a request with both could be read two ways, so
+	// it is refused
+	te, chunked := req.Headers["Transfer-Encoding"]
+	contentLength, hasLength := req.Headers["Content-Length"]
+	b := &body{limit: maxBodyBytes, expectContinue: expectContinue}
+	switch {
+	case chunked && hasLength:
+		return req, true, badRequest("both Transfer-Encoding and Content-Length")
+	case chunked:
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return req, true, &ProtocolError{StatusCode: 501, Reason: "unsupported transfer coding " + te}
+		}
+		b.src = &chunkedReader{r: reader, req: req}
+	case hasLength:
+		length, ok := parseContentLength(contentLength)
+		if !ok {
+			return req, true, badRequest("invalid Content-Length " + contentLength)
+		}
+		if length == 0 {
+			return req, true, nil
+		}
+		if maxBodyBytes > 0 && length > maxBodyBytes {
+			return req, true, &ProtocolError{StatusCode: 413, Reason: "body of " + contentLength + " bytes too large"}
+		}
+		b.src = &lengthReader{r: reader, remaining: length}
+	default:
+		return req, true, nil
 	}
+	req.Body = b
 
 	return req, true, nil
 }
+
+// parseTarget parses the request target in req.URL into req.Path,
+// req.RawQuery and req.Query. It accepts the origin form, e.g.
+// "/index.html?v=2", the absolute form, e.g.
+// "http://website1/index.html", which it rewrites to the origin form
+// and whose host it returns, and the asterisk form "*" of "OPTIONS *".
+func parseTarget(req *Request, allowEncodedSlashes bool) (host string, err error) {
+	if req.URL == "*" {
+		if req.Method != "OPTIONS" {
+			return "", badRequest("'*' is only a target for OPTIONS")
+		}
+		req.Path = "*"
+		return "", nil
+	}
+
+	target := req.URL
+	if scheme, rest, ok := strings.Cut(target, "://"); ok && (strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https")) {
+		authority := rest
+		target = "/"
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			authority, target = rest[:i], rest[i:]
+			if target[0] == '?' {
+				target = "/" + target
+			}
+		}
+		if authority == "" || strings.Contains(authority, "@") {
+			return "", badRequest("invalid authority in request target")
+		}
+		host = authority
+		req.URL = target
+	}
+	if target == "" || target[0] != '/' {
+		return "", badRequest("request target must start with '/'")
+	}
+
+	rawPath, rawQuery, hasQuery := strings.Cut(target, "?")
+	if req.Path, err = decodePath(rawPath, allowEncodedSlashes); err != nil {
+		return "", badRequest(err.Error())
+	}
+	if hasQuery {
+		req.RawQuery = rawQuery
+		// a malformed pair is left out rather than failing the request
+		req.Query, _ = url.ParseQuery(rawQuery)
+	}
+	return host, nil
+}
+
+// decodePath percent-decodes the path of a request target. It rejects
+// malformed escapes and an encoded NUL and, unless allowSlashes is set,
+// an encoded slash, which would let "a%2Fb" name a file in a
+// subdirectory that the raw path does not show.
+func decodePath(raw string, allowSlashes bool) (string, error) {
+	if !strings.Contains(raw, "%") {
+		return raw, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '%' {
+			b.WriteByte(raw[i])
+			continue
+		}
+		if i+2 >= len(raw) || !isHex(raw[i+1]) || !isHex(raw[i+2]) {
+			return "", fmt.Errorf("malformed percent-encoding in path")
+		}
+		c := unhex(raw[i+1])<<4 | unhex(raw[i+2])
+		switch {
+		case c == 0:
+			return "", fmt.Errorf("encoded NUL in path")
+		case c == '/' && !allowSlashes:
+			return "", fmt.Errorf("encoded slash in path")
+		}
+		b.WriteByte(c)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// target returns the decoded path and raw query of req. Requests not
+// read by ReadRequest, such as those built in tests, have them parsed
+// from URL on the fly.
+func (r *Request) target() (urlPath, rawQuery string) {
+	if r.Path != "" {
+		return r.Path, r.RawQuery
+	}
+	urlPath, rawQuery, _ = strings.Cut(r.URL, "?")
+	if decoded, err := decodePath(urlPath, true); err == nil {
+		urlPath = decoded
+	}
+	return urlPath, rawQuery
+}
+
+// parseContentLength parses a Content-Length value, which must be
+// nothing but decimal digits.
+func parseContentLength(value string) (int64, bool) {
+	if value == "" || len(value) > 18 {
+		return 0, false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, false
+		}
+	}
+	length, err := strconv.ParseInt(value, 10, 64)
+	return length, err == nil
+}
+
+var (
+	errBodyClosed  = errors.New("tritonhttp: read on closed body")
+	errBodyNotSent = errors.New("tritonhttp: body awaits 100 Continue")
+)
+
+// body is the Request.Body of a request that has one. It enforces the
+// size limit and, for a client that sent "Expect: 100-continue", asks
+// for the body on the first read.
+type body struct {
+	src   io.Reader // a *lengthReader or *chunkedReader
+	limit int64     // most bytes that may be read, or 0 for no limit
+	read  int64
+
+	// expectContinue is set until the first read of a body the client
+	// holds back until told to send it; sendContinue, set by the
+	// Server, tells it
+	expectContinue bool
+	sendContinue   func()
+
+	// extendDeadline, set by the Server, moves the read deadline of
+	// the connection before each read
+	extendDeadline func()
+
+	tooLarge bool
+	closed   bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	return b.readSrc(p)
+}
+
+// Close makes further reads fail. The Server still reads the rest of
+// the body, so the connection can be reused.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) readSrc(p []byte) (int, error) {
+	if b.tooLarge {
+		return 0, ErrBodyTooLarge
+	}
+	if b.expectContinue {
+		b.expectContinue = false
+		if b.sendContinue != nil {
+			b.sendContinue()
+		}
+	}
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read+1 {
+		// read one byte past the limit, to tell a body that ends
+		// right at it from one that goes on
+		p = p[:b.limit-b.read+1]
+	}
+	if b.extendDeadline != nil {
+		b.extendDeadline()
+	}
+	n, err := b.src.Read(p)
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		n -= int(b.read - b.limit)
+		b.read = b.limit
+		b.tooLarge = true
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// drain reads what is left of the body, so the next request on the
+// connection starts at the right place. It fails if the body cannot be
+// read to its end, because it is malformed or too large, or because the
+// client still waits to be told to send it.
+func (b *body) drain() error {
+	if b.expectContinue {
+		return errBodyNotSent
+	}
+	buf := make([]byte, 4096)
+	for {
+		_, err := b.readSrc(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// lengthReader reads a body of a known length.
+type lengthReader struct {
+	r         *bufio.Reader
+	remaining int64
+}
+
+func (lr *lengthReader) Read(p []byte) (int, error) {
+	if lr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// maxChunkSizeLine bounds the length of a chunk-size line, including
+// any chunk extensions.
+const maxChunkSizeLine = 4096
+
+// chunkedReader decodes a body sent with chunked transfer-encoding.
+// At the end of the body it stores the trailer fields in req.Trailer.
+type chunkedReader struct {
+	r   *bufio.Reader
+	req *Request
+
+	remaining int64 // bytes left in the current chunk
+	err       error // sticky error, io.EOF after the last chunk
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.remaining == 0 {
+		cr.remaining, cr.err = cr.readChunkSize()
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.remaining == 0 {
+			cr.err = cr.readTrailer()
+			if cr.err == nil {
+				cr.err = io.EOF
+			}
+			return 0, cr.err
+		}
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && cr.remaining == 0 {
+		// every chunk's data is followed by CRLF
+		line, _, lineErr := cr.r.ReadLine()
+		if lineErr != nil || len(line) != 0 {
+			err = fmt.Errorf("malformed chunk")
+		}
+	}
+	cr.err = err
+	return n, err
+}
+
+// readChunkSize reads a chunk-size line, ignoring chunk extensions.
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	line, isPrefix, err := cr.r.ReadLine()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if isPrefix || len(line) > maxChunkSizeLine {
+		return 0, fmt.Errorf("chunk size line too long")
+	}
+	sizeField := string(line)
+	if i := strings.IndexByte(sizeField, ';'); i >= 0 {
+		sizeField = sizeField[:i]
+	}
+	sizeField = strings.TrimSpace(sizeField)
+	// 15 hex digits keep the size well within an int64
+	if sizeField == "" || len(sizeField) > 15 {
+		return 0, fmt.Errorf("invalid chunk size %q", sizeField)
+	}
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid chunk size %q", sizeField)
+	}
+	return size, nil
+}
+
+// readTrailer reads the trailer section that ends a chunked body.
+func (cr *chunkedReader) readTrailer() error {
+	for {
+		line, _, err := cr.r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		key, value, ok := strings.Cut(string(line), ":")
+		if !ok {
+			return fmt.Errorf("malformed trailer line %q", line)
+		}
+		if cr.req.Trailer == nil {
+			cr.req.Trailer = make(map[string]string)
+		}
+		cr.req.Trailer[CanonicalHeaderKey(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+}
+
+// readLine reads one CRLF- or LF-terminated line without its line
+// ending. If the line is longer than limit bytes, tooLong is set and
+// the line is not read to its end.
+func readLine(reader *bufio.Reader, limit int) (line []byte, tooLong bool, err error) {
+	for {
+		part, isPrefix, err := reader.ReadLine()
+		line = append(line, part...)
+		if len(line) > limit {
+			return nil, true, nil
+		}
+		if err != nil || !isPrefix {
+			return line, false, err
+		}
+	}
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token, the syntax
+// of methods and header field names.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isHTTPVersion reports whether proto has the form "HTTP/x.y".
+func isHTTPVersion(proto string) bool {
+	return len(proto) == 8 && strings.HasPrefix(proto, "HTTP/") &&
+		proto[5] >= '0' && proto[5] <= '9' && proto[6] == '.' && proto[7] >= '0' && proto[7] <= '9'
+}
@@ -2,6 +2,8 @@ package tritonhttp
 
 import (
 	"bufio"
+	"io"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
@@ -81,6 +83,7 @@ func TestCombinedCalls(t *testing.T) {
 				{
 					Method:  "GET",
 					URL:     "/index.html",
+					Path:    "/index.html",
 					Proto:   "HTTP/1.1",
 					Headers: map[string]string{},
 					Host:    "test",
@@ -89,6 +92,7 @@ func TestCombinedCalls(t *testing.T) {
 				{
 					Method:  "GET",
 					URL:     "/index.html",
+					Path:    "/index.html",
 					Proto:   "HTTP/1.1",
 					Headers: map[string]string{},
 					Host:    "test",
@@ -104,6 +108,7 @@ func TestCombinedCalls(t *testing.T) {
 				{
 					Method:  "GET",
 					URL:     "/index.html",
+					Path:    "/index.html",
 					Proto:   "HTTP/1.1",
 					Headers: map[string]string{},
 					Host:    "test",
@@ -112,6 +117,46 @@ func TestCombinedCalls(t *testing.T) {
 				nil,
 			},
 		},
+		{
+			"TestHeadGood",
+			"HEAD /index.html HTTP/1.1\r\nHost: test\r\n\r\n" +
+				"GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+			[]*Request{
+				{
+					Method:  "HEAD",
+					URL:     "/index.html",
+					Path:    "/index.html",
+					Proto:   "HTTP/1.1",
+					Headers: map[string]string{},
+					Host:    "test",
+					Close:   false,
+				},
+				{
+					Method:  "GET",
+					URL:     "/index.html",
+					Path:    "/index.html",
+					Proto:   "HTTP/1.1",
+					Headers: map[string]string{},
+					Host:    "test",
+					Close:   false,
+				},
+			},
+		},
+		{
+			"TestCanonicalHeaderKeys",
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nif-none-match: \"abc\"\r\n\r\n",
+			[]*Request{
+				{
+					Method:  "GET",
+					URL:     "/index.html",
+					Path:    "/index.html",
+					Proto:   "HTTP/1.1",
+					Headers: map[string]string{"If-None-Match": "\"abc\""},
+					Host:    "test",
+					Close:   false,
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -128,3 +173,338 @@ func TestCombinedCalls(t *testing.T) {
 		})
 	}
 }
+
+func TestChunkedBody(t *testing.T) {
+	var tests = []struct {
+		name        string
+		reqText     string
+		bodyWant    string
+		trailerWant map[string]string
+		wantErr     bool
+	}{
+		{
+			"chunks and trailer",
+			"GET /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n" +
+				"7;ext=1\r\n, world\r\n" +
+				"0\r\nx-checksum: abc\r\n\r\n",
+			"hello, world",
+			map[string]string{"X-Checksum": "abc"},
+			false,
+		},
+		{
+			"no trailer",
+			"GET /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"A\r\n0123456789\r\n0\r\n\r\n",
+			"0123456789",
+			nil,
+			false,
+		},
+		{
+			"invalid chunk size",
+			"GET /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"zz\r\nhello\r\n0\r\n\r\n",
+			"",
+			nil,
+			true,
+		},
+		{
+			"missing CRLF after data",
+			"GET /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"2\r\nhello\r\n0\r\n\r\n",
+			"",
+			nil,
+			true,
+		},
+		{
+			"truncated",
+			"GET /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhel",
+			"",
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// follow each request with another one, which must still parse
+			br := bufio.NewReader(strings.NewReader(tt.reqText + "GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+			req, _, err := ReadRequest(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(req.Body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got body %q, want: error", body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.bodyWant {
+				t.Fatalf("body got: %q, want: %q", body, tt.bodyWant)
+			}
+			if !reflect.DeepEqual(req.Trailer, tt.trailerWant) {
+				t.Fatalf("trailer got: %v, want: %v", req.Trailer, tt.trailerWant)
+			}
+			if _, _, err := ReadRequest(br); err != nil {
+				t.Fatalf("next request: %v", err)
+			}
+		})
+	}
+}
+
+func TestContentLengthBody(t *testing.T) {
+	reqText := "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET / HTTP/1.1\r\nHost: test\r\nContent-Length: 0\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(reqText))
+	req, _, err := ReadRequest(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("body got: %q, want: %q", body, "hello")
+	}
+
+	req, _, err = ReadRequest(br)
+	if err != nil {
+		t.Fatalf("next request: %v", err)
+	}
+	if req.Body != nil {
+		t.Fatal("body of an empty Content-Length should be nil")
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	var tests = []struct {
+		name    string
+		reqText string
+		readErr error
+	}{
+		{
+			"content length too large",
+			"POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 11\r\n\r\nhello world",
+			nil,
+		},
+		{
+			"chunked at the limit",
+			"POST /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n",
+			nil,
+		},
+		{
+			"chunked too large",
+			"POST /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+			ErrBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _, err := readRequest(bufio.NewReader(strings.NewReader(tt.reqText)), readOptions{maxHeaderBytes: DefaultMaxHeaderBytes, maxBodyBytes: 10})
+			if strings.HasPrefix(tt.name, "content length") {
+				if perr, ok := err.(*ProtocolError); !ok || perr.StatusCode != 413 {
+					t.Fatalf("got error %v, want a 413", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != tt.readErr {
+				t.Fatalf("read error got: %v, want: %v", err, tt.readErr)
+			}
+			if len(body) > 10 {
+				t.Fatalf("read %d bytes past the limit", len(body))
+			}
+		})
+	}
+}
+
+func TestUnsupportedTransferEncoding(t *testing.T) {
+	reqText := "GET /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: gzip\r\n\r\n"
+	reqGot, _, err := ReadRequest(bufio.NewReader(strings.NewReader(reqText)))
+	checkBadRequest(t, err, reqGot)
+}
+
+func TestProtocolErrorStatus(t *testing.T) {
+	var tests = []struct {
+		name       string
+		req        string
+		statusWant int
+	}{
+		{
+			"unknown method",
+			"BREW /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+			501,
+		},
+		{
+			"malformed method",
+			"GE(T /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+			400,
+		},
+		{
+			"unsupported version",
+			"GET /index.html HTTP/1.0\r\nHost: test\r\n\r\n",
+			505,
+		},
+		{
+			"malformed version",
+			"GET /index.html HTTP/one\r\nHost: test\r\n\r\n",
+			400,
+		},
+		{
+			"request line too long",
+			"GET /" + strings.Repeat("a", maxRequestLineBytes) + " HTTP/1.1\r\nHost: test\r\n\r\n",
+			414,
+		},
+		{
+			"header section too large",
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nX-Big: " + strings.Repeat("a", DefaultMaxHeaderBytes) + "\r\n\r\n",
+			431,
+		},
+		{
+			"missing host",
+			"GET /index.html HTTP/1.1\r\nConnection: close\r\n\r\n",
+			400,
+		},
+		{
+			"duplicate host",
+			"GET /index.html HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+			400,
+		},
+		{
+			"unsupported transfer coding",
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: gzip\r\n\r\n",
+			501,
+		},
+		{
+			"invalid content length",
+			"POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: -5\r\n\r\n",
+			400,
+		},
+		{
+			"content length and chunked",
+			"POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n",
+			400,
+		},
+		{
+			"unsupported expectation",
+			"POST /upload HTTP/1.1\r\nHost: test\r\nExpect: 200-ok\r\n\r\n",
+			417,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadRequest(bufio.NewReader(strings.NewReader(tt.req)))
+			perr, ok := err.(*ProtocolError)
+			if !ok {
+				t.Fatalf("got error %v, want *ProtocolError", err)
+			}
+			if perr.StatusCode != tt.statusWant {
+				t.Fatalf("status got: %v, want: %v (%v)", perr.StatusCode, tt.statusWant, perr)
+			}
+		})
+	}
+}
+
+func TestRequestTarget(t *testing.T) {
+	var tests = []struct {
+		name      string
+		reqText   string
+		opts      readOptions
+		urlWant   string
+		pathWant  string
+		queryWant url.Values
+		hostWant  string
+	}{
+		{
+			"percent-encoded path",
+			"GET /kitten%20photo%2Ejpg HTTP/1.1\r\nHost: test\r\n\r\n",
+			readOptions{},
+			"/kitten%20photo%2Ejpg", "/kitten photo.jpg", nil, "test",
+		},
+		{
+			"query",
+			"GET /search?q=a+b&q=%C3%A9&empty HTTP/1.1\r\nHost: test\r\n\r\n",
+			readOptions{},
+			"/search?q=a+b&q=%C3%A9&empty", "/search", url.Values{"q": {"a b", "é"}, "empty": {""}}, "test",
+		},
+		{
+			"absolute form",
+			"GET http://Example.com:8080/a%20b?v=2 HTTP/1.1\r\nHost: other\r\n\r\n",
+			readOptions{},
+			"/a%20b?v=2", "/a b", url.Values{"v": {"2"}}, "Example.com:8080",
+		},
+		{
+			"absolute form without a path",
+			"GET https://example.com HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			readOptions{},
+			"/", "/", nil, "example.com",
+		},
+		{
+			"asterisk form",
+			"OPTIONS * HTTP/1.1\r\nHost: test\r\n\r\n",
+			readOptions{},
+			"*", "*", nil, "test",
+		},
+		{
+			"allowed encoded slash",
+			"GET /a%2Fb HTTP/1.1\r\nHost: test\r\n\r\n",
+			readOptions{allowEncodedSlashes: true},
+			"/a%2Fb", "/a/b", nil, "test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.maxHeaderBytes = DefaultMaxHeaderBytes
+			req, _, err := readRequest(bufio.NewReader(strings.NewReader(tt.reqText)), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL != tt.urlWant || req.Path != tt.pathWant || req.Host != tt.hostWant {
+				t.Fatalf("got URL %q, Path %q, Host %q, want: %q, %q, %q", req.URL, req.Path, req.Host, tt.urlWant, tt.pathWant, tt.hostWant)
+			}
+			if !reflect.DeepEqual(req.Query, tt.queryWant) {
+				t.Fatalf("query got: %v, want: %v", req.Query, tt.queryWant)
+			}
+		})
+	}
+}
+
+func TestBadRequestTarget(t *testing.T) {
+	var tests = []struct {
+		name string
+		url  string
+	}{
+		{"encoded slash", "/a%2fb"},
+		{"encoded nul", "/a%00b"},
+		{"malformed escape", "/a%2"},
+		{"non-hex escape", "/a%zzb"},
+		{"relative path", "index.html"},
+		{"asterisk for GET", "*"},
+		{"absolute form with userinfo", "http://user@test/"},
+		{"absolute form without host", "http:///index.html"},
+		{"unsupported scheme", "ftp://test/index.html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadRequest(bufio.NewReader(strings.NewReader("GET " + tt.url + " HTTP/1.1\r\nHost: test\r\n\r\n")))
+			if perr, ok := err.(*ProtocolError); !ok || perr.StatusCode != 400 {
+				t.Fatalf("got error %v, want a 400", err)
+			}
+		})
+	}
+}
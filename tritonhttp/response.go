@@ -25,10 +25,34 @@ type Response struct {
 	// FilePath is the local path to the file to serve.
 	// It could be "", which means there is no file to serve.
 	FilePath string
+
+	// Ranges lists the parts of FilePath to serve in a 206 response.
+	// It is nil when the whole file is served. With more than one range,
+	// the body is written as multipart/byteranges separated by Boundary.
+	Ranges   []byteRange
+	Boundary string
+
+	// Body is streamed as the response body when there is no FilePath.
+	// It could be nil, which means there is no body. If Body is also an
+	// io.Closer, it is closed once the response has been written.
+	// Without a "Content-Length" header, Body is sent chunked.
+	Body io.Reader
+
+	// Trailers are sent after a chunked Body. Their values are read
+	// once Body is exhausted, so Body may fill them in as it goes.
+	Trailers map[string]string
 }
 
 // Write writes the res to the w.
 func (res *Response) WriteResponse(w io.Writer) error {
+	chunked := res.isChunked()
+	if chunked {
+		res.Headers["Transfer-Encoding"] = "chunked"
+		if len(res.Trailers) > 0 {
+			res.Headers["Trailer"] = res.trailerNames()
+		}
+	}
+
 	// write first line (i.e: request line)
 	requestLine := res.Proto + " " + strconv.Itoa(res.StatusCode) + " " + res.StatusText + "\r\n"
 	_, err := w.Write([]byte(requestLine))
@@ -71,16 +95,166 @@ func (res *Response) WriteResponse(w io.Writer) error {
 	}
 
 	// write body (might not exist)
-	if res.FilePath != "" {
-		file, err := os.ReadFile(res.FilePath)
+	// a HEAD response carries the same headers as GET but never a body
+	if res.isHead() {
+		if closer, ok := res.Body.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil
+	}
+	if chunked {
+		cw := &chunkedWriter{w: w}
+		if err := res.writeContent(cw); err != nil {
+			return err
+		}
+		return cw.close(res.Trailers)
+	}
+	return res.writeContent(w)
+}
+
+// isChunked reports whether the length of res's body is unknown up
+// front, so it has to be sent with chunked transfer-encoding.
+func (res *Response) isChunked() bool {
+	_, hasLength := res.Headers["Content-Length"]
+	return res.Body != nil && res.FilePath == "" && !hasLength && bodyAllowed(res.StatusCode, nil)
+}
+
+// trailerNames lists the keys of res.Trailers for the "Trailer" header.
+func (res *Response) trailerNames() string {
+	names := make([]string, 0, len(res.Trailers))
+	for key := range res.Trailers {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// writeContent writes the body of res, taken from FilePath (whole or
+// by Ranges) or else from Body, to w.
+func (res *Response) writeContent(w io.Writer) error {
+	if closer, ok := res.Body.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if res.FilePath == "" {
+		return res.writeBody(w)
+	}
+	if res.Ranges != nil {
+		return res.writeRanges(w)
+	}
+	// stream the file rather than loading it into memory; when w is a
+	// *net.TCPConn, io.Copy lets the kernel do the copy via sendfile
+	file, err := os.Open(res.FilePath)
+	if err != nil {
+		log.Println("open file error: ", err)
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println("write body file error: ", err)
+		return err
+	}
+	return nil
+}
+
+// writeBody streams res.Body, if any, to w.
+func (res *Response) writeBody(w io.Writer) error {
+	if res.Body == nil {
+		return nil
+	}
+	_, err := io.Copy(w, res.Body)
+	if err != nil {
+		log.Println("write body error: ", err)
+		return err
+	}
+	return nil
+}
+
+// writeRanges writes the parts of res.FilePath selected by res.Ranges,
+// wrapping them in multipart/byteranges when there is more than one.
+func (res *Response) writeRanges(w io.Writer) error {
+	file, err := os.Open(res.FilePath)
+	if err != nil {
+		log.Println("open file error: ", err)
+		return err
+	}
+	defer file.Close()
+
+	multipart := len(res.Ranges) > 1
+	for _, r := range res.Ranges {
+		if multipart {
+			_, err = io.WriteString(w, "\r\n--"+res.Boundary+"\r\n"+r.header+"\r\n")
+			if err != nil {
+				log.Println("write part header error: ", err)
+				return err
+			}
+		}
+		// seek and CopyN rather than a SectionReader, so the copy is
+		// still eligible for sendfile
+		_, err = file.Seek(r.start, io.SeekStart)
+		if err != nil {
+			log.Println("seek file error: ", err)
+			return err
+		}
+		_, err = io.CopyN(w, file, r.length)
 		if err != nil {
-			log.Println("read file error: ", err)
+			log.Println("write body range error: ", err)
+			return err
 		}
-		_, err = w.Write(file)
+	}
+	if multipart {
+		_, err = io.WriteString(w, "\r\n--"+res.Boundary+"--\r\n")
 		if err != nil {
-			log.Println("write body file error: ", err)
+			log.Println("write closing boundary error: ", err)
 			return err
 		}
 	}
 	return nil
 }
+
+// isHead reports whether res answers a HEAD request.
+func (res *Response) isHead() bool {
+	return res.Request != nil && res.Request.Method == "HEAD"
+}
+
+// chunkedWriter writes a body with chunked transfer-encoding. Each
+// Write becomes one chunk; close writes the last chunk and trailers.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	// an empty chunk would end the body early
+	if len(p) == 0 {
+		return 0, nil
+	}
+	_, err := io.WriteString(cw.w, strconv.FormatInt(int64(len(p)), 16)+"\r\n")
+	if err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(cw.w, "\r\n")
+	return n, err
+}
+
+// close ends the body, sending trailers in sorted order.
+func (cw *chunkedWriter) close(trailers map[string]string) error {
+	keys := make([]string, 0, len(trailers))
+	for key := range trailers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	end := "0\r\n"
+	for _, key := range keys {
+		end += key + ": " + trailers[key] + "\r\n"
+	}
+	end += "\r\n"
+	_, err := io.WriteString(cw.w, end)
+	if err != nil {
+		log.Println("write last chunk error: ", err)
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package tritonhttp
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -116,6 +117,78 @@ func Test200Cases(t *testing.T) {
 				"\r\n" +
 				"test\n",
 		},
+		{
+			"with body file - HEAD request",
+			&Response{
+				StatusCode: 200,
+				Proto:      "HTTP/1.1",
+				StatusText: "OK",
+				Headers: map[string]string{
+					"Content-Length": "5",
+					"Date":           "testWriteDate",
+				},
+				Request:  &Request{Method: "HEAD"},
+				FilePath: "testFiles/index.html",
+			},
+			"HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 5\r\n" +
+				"Date: testWriteDate\r\n" +
+				"\r\n",
+		},
+		{
+			"with body file - single range",
+			&Response{
+				StatusCode: 206,
+				Proto:      "HTTP/1.1",
+				StatusText: "Partial Content",
+				Headers: map[string]string{
+					"Content-Range": "bytes 1-2/5",
+				},
+				FilePath: "testFiles/index.html",
+				Ranges:   []byteRange{{start: 1, length: 2}},
+			},
+			"HTTP/1.1 206 Partial Content\r\n" +
+				"Content-Range: bytes 1-2/5\r\n" +
+				"\r\n" +
+				"es",
+		},
+		{
+			"with body reader",
+			&Response{
+				StatusCode: 200,
+				Proto:      "HTTP/1.1",
+				StatusText: "OK",
+				Headers: map[string]string{
+					"Content-Length": "5",
+				},
+				Body: strings.NewReader("hello"),
+			},
+			"HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 5\r\n" +
+				"\r\n" +
+				"hello",
+		},
+		{
+			"with body reader - chunked with trailers",
+			&Response{
+				StatusCode: 200,
+				Proto:      "HTTP/1.1",
+				StatusText: "OK",
+				Headers:    map[string]string{},
+				Body:       strings.NewReader("hello"),
+				Trailers: map[string]string{
+					"X-Checksum": "abc",
+				},
+			},
+			"HTTP/1.1 200 OK\r\n" +
+				"Trailer: X-Checksum\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n" +
+				"0\r\n" +
+				"X-Checksum: abc\r\n" +
+				"\r\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -2,16 +2,27 @@ package tritonhttp
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"mime"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// ErrServerClosed is returned by ListenAndServe after a call to
+// Shutdown or Close.
+var ErrServerClosed = errors.New("tritonhttp: Server closed")
+
+// shutdownPollInterval is how often Shutdown checks whether all
+// connections have finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
 type Server struct {
 	// Addr specifies the TCP address for the server to listen on,
 	// in the form "host:port". It shall be passed to net.Listen()
@@ -22,153 +33,566 @@ type Server struct {
 	// (i.e. the path to the directory to serve static files from) for
 	// all virtual hosts that this server supports
 	VirtualHosts map[string]string
+
+	// Handler responds to every valid request. If nil, a FileServer
+	// serving VirtualHosts is used.
+	Handler Handler
+
+	// Hosts optionally resolves the Host header of requests to the host
+	// names in VirtualHosts, for the default FileServer and for metrics.
+	// If nil, the keys of VirtualHosts are matched, ignoring case and port.
+	Hosts *HostTable
+
+	// MetricsHosts optionally resolves the Host header of requests for
+	// the host label of metrics, in place of Hosts and VirtualHosts,
+	// such as a Reloader, whose virtual hosts change on reload.
+	MetricsHosts HostLookup
+
+	// TLSConfig optionally provides the TLS configuration used by
+	// ServeTLS and ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	// Metrics optionally collects metrics about the server's
+	// connections and requests. It may be shared by several servers.
+	Metrics *Metrics
+
+	// AccessLog optionally logs the requests the Server answers itself,
+	// without calling Handler: those that cannot be read or served,
+	// such as a 400 or 431, and the 503 to clients beyond
+	// MaxConnections. Requests that reach Handler are logged by
+	// wrapping it in AccessLog.Middleware().
+	AccessLog *AccessLogger
+
+	// ReadHeaderTimeout bounds reading the request line and headers,
+	// counted from the first byte of the request. IdleTimeout bounds
+	// the wait for the first byte of each request. If zero, both
+	// default to RECV_TIMEOUT.
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+
+	// BodyReadTimeout bounds each wait for more of a request body. It
+	// starts over with every read, so a body may take as long as it
+	// needs to arrive, as long as it keeps arriving. If zero, it
+	// defaults to RECV_TIMEOUT.
+	BodyReadTimeout time.Duration
+
+	// WriteTimeout bounds writing each response, counted from the end
+	// of its request's headers. If zero, writes have no deadline.
+	WriteTimeout time.Duration
+
+	// MaxHeaderBytes bounds the size of the header lines that follow
+	// the request line; larger requests get a 431. If zero,
+	// DefaultMaxHeaderBytes is used. The request line has a limit of
+	// its own, 8 KiB, beyond which requests get a 414.
+	MaxHeaderBytes int
+
+	// MaxBodyBytes bounds the size of request bodies. A request whose
+	// Content-Length is larger gets a 413 before its handler runs; a
+	// chunked body fails with ErrBodyTooLarge when read past the limit,
+	// and gets a 413 unless the handler responds otherwise. If zero,
+	// there is no limit.
+	MaxBodyBytes int64
+
+	// AllowEncodedSlashes lets request paths contain "%2F", which is
+	// decoded to a slash like any other escape. By default such
+	// requests get a 400.
+	AllowEncodedSlashes bool
+
+	// MaxRequestsPerConn is how many requests a connection may carry
+	// before the server closes it. If zero, there is no limit.
+	MaxRequestsPerConn int
+
+	// MaxConnections bounds the number of open connections; further
+	// clients get a 503 and are disconnected. If zero, there is no
+	// limit.
+	MaxConnections int
+
+	hostsOnce sync.Once
+	hosts     *HostTable // Hosts, or built from VirtualHosts on first use
+
+	mu         sync.Mutex
+	inShutdown bool
+	listeners  map[net.Listener]struct{}
+	listenAddr net.Addr
+	// conns maps each open connection to whether it is idle, i.e.
+	// waiting for the first byte of its next request
+	conns map[net.Conn]bool
 }
 
 // ListenAndServe listens on the TCP network address s.Addr and then
-// handles requests on incoming connections.
+// calls Serve to handle requests on incoming connections. It always
+// returns a non-nil error; after Shutdown or Close it returns
+// ErrServerClosed.
 func (s *Server) ListenAndServe() error {
-	// Hint: Validate all docRoots
-	log.Println("Validating all docRoots...")
-	for _, docRoot := range s.VirtualHosts {
-		f, err := os.Stat(docRoot)
-		if os.IsNotExist(err) {
-			log.Println("docRoot does not exist")
-			return err
-		}
-		if !f.IsDir() {
-			log.Println("docRoot is not a directory")
-			return err
-		}
+	if s.shuttingDown() {
+		return ErrServerClosed
 	}
-	log.Println("Finish validating all docRoots.")
 	// Hint: create your listen socket and spawn off goroutines per incoming client
 	log.Println("Start listening...")
 	l, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		log.Println("listen error: ", err)
+		return err
 	}
 	log.Println("finish listening.")
+	return s.Serve(l)
+}
+
+// Serve accepts incoming connections on the listener l, creating a
+// new goroutine to handle each one. Serve always closes l before
+// returning a non-nil error; after Shutdown or Close it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	if err := s.validateDocRoots(); err != nil {
+		return err
+	}
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+	if s.Metrics != nil {
+		s.Metrics.trackServer(s)
+	}
+
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Println("accept error: ", err)
+				continue
+			}
 			log.Println("accept error: ", err)
+			return err
 		}
 		go s.handleConn(conn)
 	}
 }
 
+// ListenAddr returns the network address the server is listening on,
+// which tells the actual port when Addr is ":0". It returns nil until
+// ListenAndServe or Serve has started listening.
+func (s *Server) ListenAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listenAddr
+}
+
+// validateDocRoots checks that every docRoot exists and is a directory.
+func (s *Server) validateDocRoots() error {
+	log.Println("Validating all docRoots...")
+	for _, docRoot := range s.VirtualHosts {
+		f, err := os.Stat(docRoot)
+		if err != nil {
+			log.Println("docRoot does not exist")
+			return err
+		}
+		if !f.IsDir() {
+			log.Println("docRoot is not a directory")
+			return fmt.Errorf("docRoot %s is not a directory", docRoot)
+		}
+	}
+	log.Println("Finish validating all docRoots.")
+	return nil
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting new
+// connections, closes idle ones, and waits for in-flight requests to
+// finish, closing each connection once its current response is written.
+// If ctx expires first, Shutdown returns ctx.Err() and leaves the
+// remaining connections open; Close can be used to drop them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	s.closeListenersLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections, including
+// those with requests in flight.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inShutdown = true
+	s.closeListenersLocked()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return nil
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+func (s *Server) closeListenersLocked() {
+	for l := range s.listeners {
+		l.Close()
+		delete(s.listeners, l)
+	}
+}
+
+// closeIdleConns closes every idle connection and reports whether no
+// connections remain open.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// trackListener adds or removes l from the listeners closed on
+// shutdown. Adding fails once the server is shutting down.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.inShutdown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	if s.listenAddr == nil {
+		s.listenAddr = l.Addr()
+	}
+	return true
+}
+
+// errTooManyConnections is returned by trackConn when MaxConnections
+// connections are already open.
+var errTooManyConnections = errors.New("tritonhttp: too many connections")
+
+// trackConn adds or removes conn from the open connections. A new
+// connection starts out idle. Adding fails with ErrServerClosed once
+// the server is shutting down, and with errTooManyConnections when
+// MaxConnections is reached.
+func (s *Server) trackConn(conn net.Conn, add bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, conn)
+		return nil
+	}
+	if s.inShutdown {
+		return ErrServerClosed
+	}
+	if s.MaxConnections > 0 && len(s.conns) >= s.MaxConnections {
+		return errTooManyConnections
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = true
+	return nil
+}
+
+// setIdle records whether conn is waiting for a new request. It returns
+// false if the connection was already closed by Shutdown or Close, or
+// if the server is shutting down and conn just became idle.
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; !ok {
+		return false
+	}
+	if idle && s.inShutdown {
+		return false
+	}
+	s.conns[conn] = idle
+	return true
+}
+
+// connCounts returns the number of open connections and how many of
+// them are idle.
+func (s *Server) connCounts() (open, idle int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, isIdle := range s.conns {
+		if isIdle {
+			idle++
+		}
+	}
+	return len(s.conns), idle
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+	return RECV_TIMEOUT
+}
+
+func (s *Server) bodyReadTimeout() time.Duration {
+	if s.BodyReadTimeout > 0 {
+		return s.BodyReadTimeout
+	}
+	return RECV_TIMEOUT
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return RECV_TIMEOUT
+}
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+// setWriteDeadline starts the WriteTimeout for the next response.
+func (s *Server) setWriteDeadline(conn net.Conn) {
+	if s.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+}
+
+// handler returns the Handler that serves valid requests.
+func (s *Server) handler() Handler {
+	if s.Handler != nil {
+		return s.Handler
+	}
+	return &FileServer{VirtualHosts: s.VirtualHosts, Hosts: s.hostTable()}
+}
+
+// hostTable returns Hosts or, if it is nil, a HostTable for the keys
+// of VirtualHosts, which is built once.
+func (s *Server) hostTable() *HostTable {
+	s.hostsOnce.Do(func() {
+		s.hosts = s.Hosts
+		if s.hosts == nil {
+			s.hosts = hostTableFor(s.VirtualHosts)
+		}
+	})
+	return s.hosts
+}
+
 func (s *Server) handleConn(conn net.Conn) {
+	if err := s.trackConn(conn, true); err != nil {
+		if err == errTooManyConnections {
+			start := time.Now()
+			res := s.handleErrorRequests(503)
+			s.setWriteDeadline(conn)
+			res.WriteResponse(conn)
+			s.logRejected(start, conn, nil, res)
+		}
+		conn.Close()
+		return
+	}
+	defer s.trackConn(conn, false)
+	handler := s.handler()
 	reader := bufio.NewReader(conn)
-	for {
-		// timeout 5 seconds
-		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for served := 1; ; served++ {
+		// between requests the connection is idle, so Shutdown may
+		// close it; once shutting down, stop reading new requests
+		if !s.setIdle(conn, true) {
+			conn.Close()
+			return
+		}
 
-		req, readIn, err := ReadRequest(reader)
+		conn.SetReadDeadline(time.Now().Add(s.idleTimeout()))
 
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			// if nothing read in, close the connection and return
-			if !readIn {
-				conn.Close()
-				return
+		// wait for the first byte of the next request before marking
+		// the connection active
+		if _, err := reader.Peek(1); err != nil {
+			if isTimeout(err) && s.Metrics != nil {
+				s.Metrics.observeTimeout(false)
 			}
-			// else if only partial request is being processed, return 400 error
-			res := s.handle400Requests(req)
-			res.WriteResponse(conn)
 			conn.Close()
 			return
 		}
+		if !s.setIdle(conn, false) {
+			conn.Close()
+			return
+		}
+		received := time.Now()
+
+		// the whole header section must arrive in time, however slowly
+		// it trickles in
+		conn.SetReadDeadline(time.Now().Add(s.readHeaderTimeout()))
+		req, readIn, err := readRequest(reader, readOptions{
+			maxHeaderBytes:      s.maxHeaderBytes(),
+			maxBodyBytes:        s.MaxBodyBytes,
+			allowEncodedSlashes: s.AllowEncodedSlashes,
+		})
 
-		// if error exists, 400 error
+		// if EOF or nothing could be read, close the connection
+		if err != nil && !readIn {
+			if isTimeout(err) && s.Metrics != nil {
+				s.Metrics.observeTimeout(false)
+			}
+			conn.Close()
+			return
+		}
+
+		// if the request cannot be served, answer with the status the
+		// error carries (400 for a partial request cut off by the
+		// timeout) and close the connection
 		if err != nil {
-			res := s.handle400Requests(req)
+			statusCode := 400
+			if perr, ok := err.(*ProtocolError); ok {
+				statusCode = perr.StatusCode
+			}
+			res := s.handleErrorRequests(statusCode)
+			s.setWriteDeadline(conn)
 			res.WriteResponse(conn)
+			if s.Metrics != nil {
+				if isTimeout(err) {
+					s.Metrics.observeTimeout(true)
+				}
+				size, _ := strconv.ParseInt(res.Headers["Content-Length"], 10, 64)
+				s.Metrics.observeRequest("", "", statusCode, size, 0)
+			}
+			s.logRejected(received, conn, req, res)
 			conn.Close()
 			return
 		}
 
-		// when no error exists, process the request
-		// if host not in virtualHosts or if escape document root, 404 error
-		hostName := req.Host
-		docRoot := s.VirtualHosts[hostName]
-		if docRoot == "" {
-			res := s.handle404Requests(req)
-			res.WriteResponse(conn)
-			continue
+		// when no error exists, hand the request to the handler; the
+		// body gets a fresh read window before every read
+		b, _ := req.Body.(*body)
+		if b != nil {
+			b.extendDeadline = func() {
+				conn.SetReadDeadline(time.Now().Add(s.bodyReadTimeout()))
+			}
 		}
-		absolutePath := filepath.Join(docRoot, filepath.Clean(req.URL))
-		if absolutePath[:len(docRoot)] != docRoot {
-			res := s.handle404Requests(req)
-			res.WriteResponse(conn)
-			continue
+		s.setWriteDeadline(conn)
+		req.RemoteAddr = conn.RemoteAddr().String()
+		w := newResponseWriter(conn, req)
+		if s.MaxRequestsPerConn > 0 && served >= s.MaxRequestsPerConn {
+			w.closeAfter = true
 		}
-
-		_, err = os.Stat(absolutePath)
-		if err != nil {
-			fmt.Println(err)
-			res := s.handle404Requests(req)
-			res.WriteResponse(conn)
-			continue
+		if b != nil && b.expectContinue {
+			// the client waits for the go-ahead before sending the
+			// body, which is only worth giving if no response has
+			// been sent yet
+			b.sendContinue = func() {
+				if !w.wroteHeader {
+					io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+				}
+			}
+		}
+		serve := func(rw ResponseWriter) {
+			handler.ServeTriton(rw, req)
+			if b != nil && b.tooLarge && !w.wroteHeader {
+				w.closeAfter = true
+				Error(rw, 413)
+			}
+		}
+		if s.Metrics != nil {
+			start := time.Now()
+			sr := &statusRecorder{ResponseWriter: w}
+			serve(sr)
+			w.finish()
+			if sr.status == 0 {
+				sr.status = w.status
+			}
+			s.Metrics.observeRequest(s.metricsHost(req.Host), req.Method, sr.status, sr.written, time.Since(start))
+		} else {
+			serve(w)
+			w.finish()
 		}
 
-		// else, 200 ok
-		res := s.handle200Requests(req)
-		res.WriteResponse(conn)
+		// skip whatever the handler left unread of the body, so the
+		// next request starts at the right place; a body that cannot
+		// be read to its end leaves the stream unusable
+		if b != nil && !w.closeAfter {
+			if err := b.drain(); err != nil {
+				conn.Close()
+				return
+			}
+		}
 
-		if req.Close {
+		if w.closeAfter {
 			conn.Close()
 			return
 		}
 	}
 }
 
-func (s *Server) handle400Requests(req *Request) (res *Response) {
-	res = &Response{}
-	res.Proto = "HTTP/1.1"
-	res.StatusCode = 400
-	res.StatusText = "Bad Request"
-	res.Headers = make(map[string]string)
-	res.Headers["Date"] = time.Now().Format("Tue, 19 Oct 2021 18:12:55 GMT")
-	res.Headers["Connection"] = "close"
-	res.Request = nil
-	res.FilePath = ""
-	return res
+// logRejected writes the access log line of a request on conn that the
+// Server answered with res itself; req is what could be read of it.
+func (s *Server) logRejected(start time.Time, conn net.Conn, req *Request, res *Response) {
+	if s.AccessLog == nil {
+		return
+	}
+	size, _ := strconv.ParseInt(res.Headers["Content-Length"], 10, 64)
+	s.AccessLog.logRejected(start, conn.RemoteAddr().String(), req, res.StatusCode, size)
 }
 
-func (s *Server) handle200Requests(req *Request) (res *Response) {
-	res = &Response{}
-	res.Proto = "HTTP/1.1"
-	res.StatusCode = 200
-	res.StatusText = "OK"
-	res.Headers = make(map[string]string)
-	res.Headers["Date"] = time.Now().Format("Tue, 19 Oct 2021 18:12:55 GMT")
-	absolutePath := filepath.Join(s.VirtualHosts[req.Host], filepath.Clean(req.URL))
-	f, _ := os.Stat(absolutePath)
-	res.Headers["Last-Modified"] = f.ModTime().Format("Tue, 19 Oct 2021 18:12:55 GMT")
-	res.Headers["Content-Type"] = mime.TypeByExtension(filepath.Ext(absolutePath))
-	fi, err := os.Stat(absolutePath)
-	if err != nil {
-		log.Fatal(err)
+// metricsHost returns the host label for requests to host, which is
+// the name of the virtual host it resolves to. Hosts that resolve to
+// none, such as every host of a server without VirtualHosts, share one
+// label, so clients cannot make up new series at will.
+func (s *Server) metricsHost(host string) string {
+	var hosts HostLookup = s.hostTable()
+	if s.MetricsHosts != nil {
+		hosts = s.MetricsHosts
 	}
-	res.Headers["Content-Length"] = strconv.FormatInt(fi.Size(), 10)
-	if req.Close {
-		res.Headers["Connection"] = "close"
+	if name := hosts.Lookup(host); name != "" {
+		return name
 	}
-	res.Request = req
-	res.FilePath = absolutePath
+	return "other"
+}
+
+// isTimeout reports whether err comes from a passed deadline.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func (s *Server) handleErrorRequests(statusCode int) (res *Response) {
+	res = newErrorResponse(nil, statusCode)
+	res.Headers["Connection"] = "close"
 	return res
 }
 
-func (s *Server) handle404Requests(req *Request) (res *Response) {
+// newErrorResponse builds an error response with the given status to
+// req, which may be nil if the request could not be read. Its body is
+// the built-in error page.
+func newErrorResponse(req *Request, statusCode int) (res *Response) {
 	res = &Response{}
 	res.Proto = "HTTP/1.1"
-	res.StatusCode = 404
-	res.StatusText = "Not Found"
+	res.StatusCode = statusCode
+	res.StatusText = StatusText(statusCode)
 	res.Headers = make(map[string]string)
-	res.Headers["Date"] = time.Now().Format("Tue, 19 Oct 2021 18:12:55 GMT")
-	if req.Close {
+	res.Headers["Date"] = FormatTime(time.Now())
+	if req != nil && req.Close {
 		res.Headers["Connection"] = "close"
 	}
 	res.Request = req
-	res.FilePath = ""
+	res.setDefaultErrorPage()
 	return res
 }
@@ -1,83 +1,522 @@
 package tritonhttp
 
 import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
-const (
-	contentTypeHTML = "text/html; charset=utf-8"
-	contentTypeJPG  = "image/jpeg"
-	contentTypePNG  = "image/png"
-)
+// serveOne sends reqText to a connection handled by s and parses the
+// single response that comes back.
+func serveOne(t *testing.T, s *Server, reqText string) (*http.Response, string) {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.handleConn(server)
+	defer client.Close()
+
+	go io.WriteString(client, reqText)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestHandlerChain(t *testing.T) {
+	hello := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header()["Content-Length"] = "6"
+		io.WriteString(w, "hello\n")
+	})
+	s := &Server{
+		Handler: Chain(hello,
+			SetHeaders(map[string]string{"X-Frame-Options": "DENY"}),
+			BasicAuth("test", map[string]string{"alice": "secret"}),
+		),
+	}
 
-func TestHandleGoodRequest(t *testing.T) {
 	var tests = []struct {
-		name             string
-		req              *Request
-		statusWant       int
-		headersWant      []string
-		headerValuesWant map[string]string
-		filePathWant     string // relative to doc root
+		name       string
+		auth       string
+		statusWant int
+		bodyWant   string
 	}{
-		{
-			"OKBasic",
-			&Request{
-				Method:  "GET",
-				URL:     "/index.html",
-				Proto:   "HTTP/1.1",
-				Headers: map[string]string{},
-				Host:    "website1",
-				Close:   false,
-			},
-			200,
-			[]string{
-				"Date",
-				"Last-Modified",
-			},
-			map[string]string{
-				"Content-Type":   contentTypeHTML,
-				"Content-Length": "377",
-			},
-			"../docroot_dirs/htdocs1/index.html",
-		},
-	}
-	virtualHosts := ParseVHConfigFile("../virtual_hosts.yaml", "../docroot_dirs")
-
+		{"no credentials", "", 401, "401 Unauthorized\n"},
+		{"wrong password", "Basic YWxpY2U6d3Jvbmc=", 401, "401 Unauthorized\n"},
+		{"authorized", "Basic YWxpY2U6c2VjcmV0", 200, "hello\n"},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Server{
-				Addr:         ":0",
-				VirtualHosts: virtualHosts,
+			reqText := "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"
+			if tt.auth != "" {
+				reqText += "Authorization: " + tt.auth + "\r\n"
 			}
-			res := s.handle200Requests(tt.req)
-			if res.StatusCode != tt.statusWant {
-				t.Fatalf("status code got: %v, want: %v", res.StatusCode, tt.statusWant)
+			resp, body := serveOne(t, s, reqText+"\r\n")
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
 			}
-			for _, h := range tt.headersWant {
-				if _, ok := res.Headers[h]; !ok {
-					t.Fatalf("missing header %q", h)
-				}
+			if body != tt.bodyWant {
+				t.Fatalf("body got: %q, want: %q", body, tt.bodyWant)
 			}
-			for h, vWant := range tt.headerValuesWant {
-				v, ok := res.Headers[h]
-				if !ok {
-					t.Fatalf("missing header %q", h)
-				}
-				if v != vWant {
-					t.Fatalf("header %q value got: %q, want %q", h, v, vWant)
-				}
+			if resp.Header.Get("X-Frame-Options") != "DENY" {
+				t.Fatal("missing header set by middleware")
 			}
-			if tt.filePathWant != "" {
-				// Case with file to serve
-				if res.FilePath != tt.filePathWant {
-					t.Fatalf("file path (relative to testdata/) got: %q, want: %q", res.FilePath, tt.filePathWant)
-				}
-			} else {
-				// Case with no file to serve
-				if res.FilePath != tt.filePathWant {
-					t.Fatalf("file path got: %q, want: %q", res.FilePath, tt.filePathWant)
-				}
+			if resp.Header.Get("Date") == "" {
+				t.Fatal("missing Date header")
 			}
 		})
 	}
 }
+
+func TestEmptyHandlerResponse(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	resp, body := serveOne(t, s, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if resp.StatusCode != 200 || resp.ContentLength != 0 || body != "" {
+		t.Fatalf("got status %v, length %v, body %q; want an empty 200", resp.StatusCode, resp.ContentLength, body)
+	}
+}
+
+func TestShutdownDrainsActiveRequests(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		close(started)
+		<-release
+		w.Header()["Content-Length"] = "4"
+		io.WriteString(w, "done")
+	})}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	<-started
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown returned %v while a request was in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	br := bufio.NewReader(client)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Fatalf("body got: %q, want: %q", body, "done")
+	}
+	if err := <-shutdownDone; err != nil {
+		t.Fatal(err)
+	}
+	// the keep-alive connection is closed after the in-flight response
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	client, server := net.Pipe()
+	defer client.Close()
+	connDone := make(chan struct{})
+	go func() {
+		s.handleConn(server)
+		close(connDone)
+	}()
+	// make sure the connection has been registered before shutting down
+	serveOneOn(t, client, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-connDone
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		close(started)
+		<-block
+	})}
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	s.Close()
+}
+
+// serveOneOn writes reqText on client and reads back one response.
+func serveOneOn(t *testing.T, client net.Conn, reqText string) *http.Response {
+	t.Helper()
+	go io.WriteString(client, reqText)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	return resp
+}
+
+func TestListenAddr(t *testing.T) {
+	s := &Server{Addr: "127.0.0.1:0", VirtualHosts: map[string]string{}}
+	if s.ListenAddr() != nil {
+		t.Fatal("ListenAddr should be nil before listening")
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.ListenAndServe()
+	}()
+
+	var addr net.Addr
+	for i := 0; i < 100 && addr == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		addr = s.ListenAddr()
+	}
+	if addr == nil {
+		t.Fatal("ListenAddr still nil after starting ListenAndServe")
+	}
+	if addr.(*net.TCPAddr).Port == 0 {
+		t.Fatal("ListenAddr did not report the chosen port")
+	}
+
+	s.Close()
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Fatalf("got: %v, want: %v", err, ErrServerClosed)
+	}
+}
+
+func TestChunkedResponse(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header()["Trailer"] = "X-Parts"
+		io.WriteString(w, "hello, ")
+		io.WriteString(w, "world")
+		w.Header()["X-Parts"] = "2"
+	})}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	// two requests on one connection: the chunked response must not
+	// close it
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	br := bufio.NewReader(client)
+	for i := 0; i < 2; i++ {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+			t.Fatalf("transfer encoding got: %v, want: chunked", resp.TransferEncoding)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "hello, world" {
+			t.Fatalf("body got: %q, want: %q", body, "hello, world")
+		}
+		if got := resp.Trailer.Get("X-Parts"); got != "2" {
+			t.Fatalf("trailer got: %q, want: %q", got, "2")
+		}
+	}
+}
+
+func TestUnreadRequestBodyIsSkipped(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header()["Content-Length"] = strconv.Itoa(len(r.URL))
+		io.WriteString(w, r.URL)
+	})}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	go io.WriteString(client, "GET /first HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"3\r\nabc\r\n0\r\n\r\n"+
+		"GET /second HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	br := bufio.NewReader(client)
+	for _, want := range []string{"/first", "/second"} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want {
+			t.Fatalf("body got: %q, want: %q", body, want)
+		}
+	}
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	s := &Server{MaxRequestsPerConn: 2, Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header()["Content-Length"] = "0"
+	})}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		s.handleConn(server)
+		close(done)
+	}()
+	go io.WriteString(client, "GET /1 HTTP/1.1\r\nHost: test\r\n\r\nGET /2 HTTP/1.1\r\nHost: test\r\n\r\n")
+	br := bufio.NewReader(client)
+	for i, closeWant := range []bool{false, true} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Close != closeWant {
+			t.Fatalf("response %d close got: %v, want: %v", i+1, resp.Close, closeWant)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after MaxRequestsPerConn requests")
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	s := &Server{MaxConnections: 1, VirtualHosts: map[string]string{}}
+
+	// hold the only slot with an idle connection
+	idle, server := net.Pipe()
+	defer idle.Close()
+	go s.handleConn(server)
+	for i := 0; i < 100; i++ {
+		if open, _ := s.connCounts(); open == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 503 || !resp.Close {
+		t.Fatalf("got: %v (close %v), want: 503 with close", resp.StatusCode, resp.Close)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	s := &Server{MaxHeaderBytes: 64, VirtualHosts: map[string]string{}}
+	resp, _ := serveOne(t, s, "GET / HTTP/1.1\r\nHost: test\r\nX-Padding: "+strings.Repeat("a", 100)+"\r\n\r\n")
+	if resp.StatusCode != 431 {
+		t.Fatalf("got: %v, want: 431", resp.StatusCode)
+	}
+}
+
+func TestReadTimeouts(t *testing.T) {
+	s := &Server{
+		IdleTimeout:       50 * time.Millisecond,
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		VirtualHosts:      map[string]string{},
+	}
+
+	t.Run("partial request", func(t *testing.T) {
+		// the header section never ends, so it is cut off with a 400
+		resp, _ := serveOne(t, s, "GET / HTTP/1.1\r\nHost: test\r\n")
+		if resp.StatusCode != 400 || !resp.Close {
+			t.Fatalf("got: %v (close %v), want: 400 with close", resp.StatusCode, resp.Close)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go s.handleConn(server)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("got: %v, want: %v", err, io.EOF)
+		}
+	})
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	echo := HandlerFunc(func(w ResponseWriter, r *Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			Error(w, 400)
+			return
+		}
+		w.Header()["Content-Length"] = strconv.Itoa(len(body))
+		w.Write(body)
+	})
+	s := &Server{
+		Handler:           echo,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		BodyReadTimeout:   100 * time.Millisecond,
+	}
+	send := func(pieces []string, pause time.Duration) (*http.Response, string) {
+		t.Helper()
+		client, server := net.Pipe()
+		defer client.Close()
+		go s.handleConn(server)
+		go func() {
+			io.WriteString(client, "PUT /f HTTP/1.1\r\nHost: test\r\nConnection: close\r\nContent-Length: 15\r\n\r\n")
+			for _, piece := range pieces {
+				time.Sleep(pause)
+				io.WriteString(client, piece)
+			}
+		}()
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	t.Run("trickled body", func(t *testing.T) {
+		// the body takes several timeout windows, but keeps arriving
+		resp, body := send([]string{"abc", "def", "ghi", "jkl", "mno"}, 60*time.Millisecond)
+		if resp.StatusCode != 200 || body != "abcdefghijklmno" {
+			t.Fatalf("got: %v %q, want: 200 %q", resp.StatusCode, body, "abcdefghijklmno")
+		}
+	})
+
+	t.Run("stalled body", func(t *testing.T) {
+		resp, _ := send([]string{"abc", "def"}, 300*time.Millisecond)
+		if resp.StatusCode != 400 {
+			t.Fatalf("status code got: %v, want: 400", resp.StatusCode)
+		}
+	})
+}
+
+func TestRequestBody(t *testing.T) {
+	echo := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL == "/skip" {
+			w.Header()["Content-Length"] = "0"
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			Error(w, 400)
+			return
+		}
+		w.Header()["Content-Length"] = strconv.Itoa(len(body))
+		w.Write(body)
+	})
+	s := &Server{Handler: echo, MaxBodyBytes: 10}
+
+	t.Run("pipelined", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go s.handleConn(server)
+		// the unread body of /skip must not be taken for a request
+		go io.WriteString(client, "POST /skip HTTP/1.1\r\nHost: test\r\nContent-Length: 9\r\n\r\nGET / bad"+
+			"POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello")
+		br := bufio.NewReader(client)
+		for _, want := range []string{"", "hello"} {
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != 200 || string(body) != want {
+				t.Fatalf("got: %v %q, want: 200 %q", resp.StatusCode, body, want)
+			}
+		}
+	})
+
+	t.Run("content length too large", func(t *testing.T) {
+		resp, _ := serveOne(t, s, "POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 11\r\n\r\nhello world")
+		if resp.StatusCode != 413 || !resp.Close {
+			t.Fatalf("got: %v (close %v), want: 413 with close", resp.StatusCode, resp.Close)
+		}
+	})
+
+	t.Run("chunked too large", func(t *testing.T) {
+		// the handler answers 400 to any read error, so check that the
+		// body limit is reported instead through a handler that
+		// ignores it
+		s := &Server{MaxBodyBytes: 10, Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			io.Copy(io.Discard, r.Body)
+		})}
+		resp, _ := serveOne(t, s, "POST /echo HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n")
+		if resp.StatusCode != 413 || !resp.Close {
+			t.Fatalf("got: %v (close %v), want: 413 with close", resp.StatusCode, resp.Close)
+		}
+	})
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL == "/refuse" {
+			Error(w, 403)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header()["Content-Length"] = strconv.Itoa(len(body))
+		w.Write(body)
+	})}
+	reqHead := func(path string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"
+	}
+
+	t.Run("read", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go s.handleConn(server)
+		go io.WriteString(client, reqHead("/echo"))
+		br := bufio.NewReader(client)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 100 {
+			t.Fatalf("got: %v, want: 100", resp.StatusCode)
+		}
+		go io.WriteString(client, "hello")
+		resp, err = http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || string(body) != "hello" {
+			t.Fatalf("got: %v %q, want: 200 %q", resp.StatusCode, body, "hello")
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		// the body is never asked for, so the connection cannot be
+		// reused
+		resp, _ := serveOne(t, s, reqHead("/refuse"))
+		if resp.StatusCode != 403 || !resp.Close {
+			t.Fatalf("got: %v (close %v), want: 403 with close", resp.StatusCode, resp.Close)
+		}
+	})
+}
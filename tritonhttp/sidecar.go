@@ -0,0 +1,73 @@
+package tritonhttp
+
+import (
+	"os"
+	"strings"
+)
+
+// sidecarExtensions maps the content-codings that may be stored
+// precompressed next to a file to their file extensions, in order of
+// preference when the client rates them equally.
+var sidecarExtensions = []struct {
+	coding string
+	ext    string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// sidecar is a precompressed copy of a file, e.g. index.html.gz next
+// to index.html, built ahead of time so it need not be compressed per
+// request.
+type sidecar struct {
+	path   string
+	fi     os.FileInfo
+	coding string
+}
+
+// etag returns the entity-tag of the sidecar, which differs from that
+// of the original file and of sidecars in other codings.
+func (sc *sidecar) etag() string {
+	return strings.TrimSuffix(generateETag(sc.fi), "\"") + "-" + sc.coding + "\""
+}
+
+// selectSidecar returns the sidecar to serve for req instead of the file
+// at absolutePath, or nil if the file itself should be served. Only
+// sidecars at least as fresh as the file are used. vary reports whether
+// any fresh sidecar exists, in which case the response depends on the
+// request's Accept-Encoding.
+func selectSidecar(req *Request, absolutePath string, fi os.FileInfo) (sc *sidecar, vary bool) {
+	var fresh []*sidecar
+	for _, candidate := range sidecarExtensions {
+		sfi, err := os.Stat(absolutePath + candidate.ext)
+		if err != nil || !sfi.Mode().IsRegular() || sfi.ModTime().Before(fi.ModTime()) {
+			continue
+		}
+		fresh = append(fresh, &sidecar{path: absolutePath + candidate.ext, fi: sfi, coding: candidate.coding})
+	}
+	if len(fresh) == 0 {
+		return nil, false
+	}
+
+	// byte ranges are served from the file itself
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return nil, true
+	}
+	if _, ok := req.Headers["Range"]; ok {
+		return nil, true
+	}
+
+	qvalues := parseAcceptEncoding(req.Headers["Accept-Encoding"])
+	var best *sidecar
+	bestQ := 0.0
+	for _, candidate := range fresh {
+		q, ok := qvalues[candidate.coding]
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = candidate, q
+		}
+	}
+	return best, true
+}
@@ -0,0 +1,88 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSidecars(t *testing.T) {
+	docRoot := t.TempDir()
+	files := map[string]string{
+		"page.html":     "<p>original</p>",
+		"page.html.gz":  "gzip bytes",
+		"page.html.br":  "brotli bytes",
+		"stale.html":    "<p>newer than its sidecar</p>",
+		"stale.html.gz": "stale gzip bytes",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the files are written in map order, so set the times explicitly:
+	// every sidecar but stale.html.gz is newer than its original
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"page.html", "stale.html.gz"} {
+		if err := os.Chtimes(filepath.Join(docRoot, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &FileServer{VirtualHosts: map[string]string{"test": docRoot}}
+	s := &Server{Handler: fs}
+
+	var tests = []struct {
+		name     string
+		url      string
+		headers  string
+		bodyWant string
+		encWant  string
+		varyWant bool
+	}{
+		{"gzip accepted", "/page.html", "Accept-Encoding: gzip\r\n", "gzip bytes", "gzip", true},
+		{"brotli preferred", "/page.html", "Accept-Encoding: gzip, br\r\n", "brotli bytes", "br", true},
+		{"gzip rated higher", "/page.html", "Accept-Encoding: gzip, br;q=0.5\r\n", "gzip bytes", "gzip", true},
+		{"nothing accepted", "/page.html", "", "<p>original</p>", "", true},
+		{"range uses original", "/page.html", "Accept-Encoding: gzip\r\nRange: bytes=0-2\r\n", "<p>", "", true},
+		{"stale sidecar ignored", "/stale.html", "Accept-Encoding: gzip\r\n", "<p>newer than its sidecar</p>", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serveOne(t, s, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"+tt.headers+"\r\n")
+			if body != tt.bodyWant {
+				t.Fatalf("body got: %q, want: %q", body, tt.bodyWant)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.encWant {
+				t.Fatalf("Content-Encoding got: %q, want: %q", got, tt.encWant)
+			}
+			if got := resp.Header.Get("Content-Type"); got != contentTypeHTML {
+				t.Fatalf("Content-Type got: %q, want: %q", got, contentTypeHTML)
+			}
+			if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(tt.bodyWant)) {
+				t.Fatalf("Content-Length got: %q, want: %v", got, len(tt.bodyWant))
+			}
+			if got := resp.Header.Get("Vary") == "Accept-Encoding"; got != tt.varyWant {
+				t.Fatalf("Vary got: %q, want Accept-Encoding: %v", resp.Header.Get("Vary"), tt.varyWant)
+			}
+		})
+	}
+
+	t.Run("distinct etags and 304", func(t *testing.T) {
+		identity, _ := serveOne(t, s, "GET /page.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		gzipped, _ := serveOne(t, s, "GET /page.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\nAccept-Encoding: gzip\r\n\r\n")
+		etag := gzipped.Header.Get("ETag")
+		if etag == identity.Header.Get("ETag") {
+			t.Fatalf("sidecar shares the original's ETag %q", etag)
+		}
+		resp, _ := serveOne(t, s, "GET /page.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"+
+			"Accept-Encoding: gzip\r\nIf-None-Match: "+etag+"\r\n\r\n")
+		if resp.StatusCode != 304 {
+			t.Fatalf("status code got: %v, want: %v", resp.StatusCode, 304)
+		}
+		if resp.Header.Get("ETag") != etag {
+			t.Fatalf("304 ETag got: %q, want: %q", resp.Header.Get("ETag"), etag)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package tritonhttp
+
+// statusText maps the status codes this server can send to their
+// reason phrases.
+var statusText = map[int]string{
+	100: "Continue",
+	200: "OK",
+	201: "Created",
+	204: "No Content",
+	206: "Partial Content",
+	207: "Multi-Status",
+	301: "Moved Permanently",
+	304: "Not Modified",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	409: "Conflict",
+	412: "Precondition Failed",
+	413: "Payload Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	417: "Expectation Failed",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	505: "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for the HTTP status code,
+// or "" if the code is unknown.
+func StatusText(code int) string {
+	return statusText[code]
+}
@@ -0,0 +1,174 @@
+package tritonhttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ListenAndServeTLS listens on the TCP network address s.Addr and then
+// calls ServeTLS to handle requests on incoming TLS connections.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+// ServeTLS is like Serve but performs a TLS handshake on every
+// connection. Certificates come from s.TLSConfig, which may select one
+// per virtual host through GetCertificate (see LoadTLSConfig). If
+// certFile and keyFile are not empty, that pair is added as the default
+// certificate.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		config.Certificates = append([]tls.Certificate{cert}, config.Certificates...)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		l.Close()
+		return errors.New("tritonhttp: no TLS certificate configured")
+	}
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// LoadTLSConfig loads the certificates named in the tls sections of
+// config, as returned by LoadConfig, with relative paths resolved
+// against the config file. The returned tls.Config picks each host's
+// certificate by the SNI name in the client hello, matched like the
+// Host header, falling back to the top-level default certificate, then
+// to the default host's, and then to the tls.Config's Certificates,
+// where ServeTLS puts the certificate it is given. It returns nil if
+// config has no tls sections.
+func LoadTLSConfig(config *Config) (*tls.Config, error) {
+	byHost := make(map[string]*tls.Certificate)
+	for _, vhost := range config.VirtualHosts {
+		if vhost.TLS == nil {
+			continue
+		}
+		cert, err := vhost.TLS.load(config)
+		if err != nil {
+			return nil, fmt.Errorf("tls certificate for %s: %v", vhost.HostName, err)
+		}
+		byHost[vhost.HostName] = cert
+	}
+	var defaultCert *tls.Certificate
+	if config.TLS != nil {
+		var err error
+		defaultCert, err = config.TLS.load(config)
+		if err != nil {
+			return nil, fmt.Errorf("default tls certificate: %v", err)
+		}
+	}
+	if len(byHost) == 0 && defaultCert == nil {
+		return nil, nil
+	}
+	hosts := config.HostTable()
+
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if name, ok := hosts.Match(hello.ServerName); ok && byHost[name] != nil {
+				return byHost[name], nil
+			}
+			if defaultCert != nil {
+				return defaultCert, nil
+			}
+			if cert, ok := byHost[hosts.Lookup(hello.ServerName)]; ok {
+				return cert, nil
+			}
+			// nil lets crypto/tls use config.Certificates
+			return nil, nil
+		},
+	}, nil
+}
+
+func (tf *TLSFiles) load(config *Config) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(config.resolvePath(tf.CertFile), config.resolvePath(tf.KeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// RedirectHTTPS returns a Handler that permanently redirects every
+// request to the same host and URL over HTTPS on httpsPort.
+func RedirectHTTPS(httpsPort int) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		w.Header()["Location"] = "https://" + host + r.URL
+		Error(w, 301)
+	})
+}
+
+// GenerateSelfSigned creates a self-signed certificate valid for the
+// given host names and IP addresses for one year, and returns the
+// PEM-encoded certificate and private key. It is meant for tests and
+// local development only.
+func GenerateSelfSigned(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"TritonHTTP self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
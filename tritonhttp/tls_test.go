@@ -0,0 +1,183 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSelfSigned writes a self-signed certificate for hosts into dir
+// and returns its PEM bytes.
+func writeSelfSigned(t *testing.T, dir, name string, hosts []string) []byte {
+	t.Helper()
+	certPEM, keyPEM, err := GenerateSelfSigned(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func TestServeTLSWithSNI(t *testing.T) {
+	dir := t.TempDir()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(writeSelfSigned(t, dir, "website1", []string{"website1"}))
+	roots.AppendCertsFromPEM(writeSelfSigned(t, dir, "default", []string{"fallback.test"}))
+	config := "virtual_hosts:\n" +
+		"  - hostName: \"website1\"\n" +
+		"    docRoot: \"htdocs1\"\n" +
+		"    aliases: [\"www.website1\"]\n" +
+		"    tls:\n" +
+		"      certFile: \"website1.pem\"\n" +
+		"      keyFile: \"website1-key.pem\"\n" +
+		"tls:\n" +
+		"  certFile: \"default.pem\"\n" +
+		"  keyFile: \"default-key.pem\"\n"
+	configPath := filepath.Join(dir, "virtual_hosts.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vhConfig, err := LoadConfig(configPath, "../docroot_dirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := LoadTLSConfig(vhConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		VirtualHosts: map[string]string{"website1": "../docroot_dirs/htdocs1"},
+		TLSConfig:    tlsConfig,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeTLS(l, "", "")
+	defer s.Close()
+
+	var tests = []struct {
+		name       string
+		serverName string
+		verifyAs   string
+	}{
+		{"vhost certificate", "website1", "website1"},
+		{"alias gets vhost certificate", "www.website1", "website1"},
+		{"default certificate", "unknown.test", "fallback.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+				ServerName:         tt.serverName,
+				InsecureSkipVerify: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			cert := conn.ConnectionState().PeerCertificates[0]
+			if _, err := cert.Verify(x509.VerifyOptions{DNSName: tt.verifyAs, Roots: roots}); err != nil {
+				t.Fatalf("served certificate does not match %q: %v", tt.verifyAs, err)
+			}
+
+			io.WriteString(conn, "GET /index.html HTTP/1.1\r\nHost: website1\r\nConnection: close\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, 200)
+			}
+		})
+	}
+}
+
+func TestServeTLSWithDefaultFromFlags(t *testing.T) {
+	dir := t.TempDir()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(writeSelfSigned(t, dir, "website1", []string{"website1"}))
+	roots.AppendCertsFromPEM(writeSelfSigned(t, dir, "default", []string{"localhost"}))
+	config := "virtual_hosts:\n" +
+		"  - hostName: \"website1\"\n" +
+		"    docRoot: \"htdocs1\"\n" +
+		"    tls:\n" +
+		"      certFile: \"website1.pem\"\n" +
+		"      keyFile: \"website1-key.pem\"\n"
+	configPath := filepath.Join(dir, "virtual_hosts.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vhConfig, err := LoadConfig(configPath, "../docroot_dirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := LoadTLSConfig(vhConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		VirtualHosts: map[string]string{"website1": "../docroot_dirs/htdocs1"},
+		TLSConfig:    tlsConfig,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default certificate comes from -tls_cert and -tls_key
+	go s.ServeTLS(l, filepath.Join(dir, "default.pem"), filepath.Join(dir, "default-key.pem"))
+	defer s.Close()
+
+	for serverName, verifyAs := range map[string]string{"website1": "website1", "localhost": "localhost"} {
+		t.Run(serverName, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+				ServerName:         serverName,
+				InsecureSkipVerify: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			cert := conn.ConnectionState().PeerCertificates[0]
+			if _, err := cert.Verify(x509.VerifyOptions{DNSName: verifyAs, Roots: roots}); err != nil {
+				t.Fatalf("served certificate does not match %q: %v", verifyAs, err)
+			}
+		})
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	var tests = []struct {
+		name         string
+		port         int
+		host         string
+		locationWant string
+	}{
+		{"default port", 443, "website1:8080", "https://website1/kitten.jpg"},
+		{"custom port", 8443, "website1", "https://website1:8443/kitten.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Handler: RedirectHTTPS(tt.port)}
+			resp, _ := serveOne(t, s, "GET /kitten.jpg HTTP/1.1\r\nHost: "+tt.host+"\r\nConnection: close\r\n\r\n")
+			if resp.StatusCode != 301 {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, 301)
+			}
+			if got := resp.Header.Get("Location"); got != tt.locationWant {
+				t.Fatalf("Location got: %q, want: %q", got, tt.locationWant)
+			}
+		})
+	}
+}
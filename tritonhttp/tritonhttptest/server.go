@@ -0,0 +1,42 @@
+// Package tritonhttptest provides utilities for testing against a
+// running TritonHTTP server, analogous to net/http/httptest.
+package tritonhttptest
+
+import (
+	"log"
+	"net"
+
+	"cse224/tritonhttp"
+)
+
+// NewServer starts a TritonHTTP server on a random loopback port that
+// serves the given virtual hosts, which map host names to docRoot paths.
+// It returns the server's base URL, e.g. "http://127.0.0.1:41234", and a
+// cleanup func that shuts the server down.
+func NewServer(virtualHosts map[string]string) (url string, cleanup func()) {
+	return Start(&tritonhttp.Server{VirtualHosts: virtualHosts})
+}
+
+// Start runs s on a random loopback port instead of s.Addr. It returns
+// the server's base URL and a cleanup func that closes the server and
+// waits for it to stop serving.
+func Start(s *tritonhttp.Server) (url string, cleanup func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("tritonhttptest: failed to listen on a port: " + err.Error())
+	}
+	s.Addr = l.Addr().String()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.Serve(l); err != tritonhttp.ErrServerClosed {
+			log.Printf("tritonhttptest: serve error: %v", err)
+		}
+	}()
+
+	return "http://" + s.Addr, func() {
+		s.Close()
+		<-done
+	}
+}
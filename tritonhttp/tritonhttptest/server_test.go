@@ -0,0 +1,52 @@
+package tritonhttptest
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Suppress logging for all tests in this package
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestNewServer(t *testing.T) {
+	url, cleanup := NewServer(map[string]string{
+		"website1": "../../docroot_dirs/htdocs1",
+	})
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", url+"/index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "website1"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status code got: %v, want: %v", resp.StatusCode, 200)
+	}
+	if len(body) != 377 {
+		t.Fatalf("body length got: %v, want: %v", len(body), 377)
+	}
+}
+
+func TestCleanupStopsServer(t *testing.T) {
+	url, cleanup := NewServer(map[string]string{})
+	cleanup()
+	if _, err := http.Get(url + "/"); err == nil {
+		t.Fatal("server still reachable after cleanup")
+	}
+}
@@ -0,0 +1,307 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFormBytes bounds the form fields of an upload, which are held in
+// memory, unlike files.
+const maxFormBytes = 1 << 20
+
+// UploadOptions configures the endpoint of a virtual host that stores
+// the files of HTML forms POSTed to it.
+type UploadOptions struct {
+	// Path is the URL path forms are POSTed to, such as /upload
+	Path string `yaml:"path"`
+
+	// Dir is the directory inside the docRoot that files are stored in
+	Dir string `yaml:"dir"`
+
+	// MaxFileBytes bounds the size of each file. If zero, only
+	// Server.MaxBodyBytes applies.
+	MaxFileBytes int64 `yaml:"maxFileBytes"`
+
+	// AllowedTypes lists the media types files may have, such as
+	// image/png, or image/* for any image. If empty, any type is
+	// allowed.
+	AllowedTypes []string `yaml:"allowedTypes"`
+}
+
+// allowsType reports whether a file of mediaType may be stored.
+func (o *UploadOptions) allowsType(mediaType string) bool {
+	if len(o.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range o.AllowedTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadResult is the JSON body of the response to an upload.
+type uploadResult struct {
+	Files  []uploadedFile      `json:"files"`
+	Fields map[string][]string `json:"fields"`
+}
+
+// uploadedFile describes one stored file.
+type uploadedFile struct {
+	Field       string `json:"field"`
+	Name        string `json:"name"` // as sent by the client
+	URL         string `json:"url"`  // where it is served
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+}
+
+// serveUpload handles a form POSTed to the upload endpoint of a virtual
+// host. Its fields, and the files of a multipart form, are reported
+// back as JSON; files are streamed to the upload directory as they
+// arrive. If any part of the form is refused, no file is kept.
+func (fs *FileServer) serveUpload(req *Request) *Response {
+	hostName := fs.virtualHost(req)
+	if fs.VirtualHosts[hostName] == "" {
+		return fs.handle404Requests(req)
+	}
+	if !fs.methodAllowed(req) {
+		return fs.handle405Requests(req)
+	}
+	upload := fs.HostOptions[hostName].Upload
+	dir := filepath.Join(fs.VirtualHosts[hostName], filepath.Clean("/"+upload.Dir))
+
+	result := &uploadResult{Files: []uploadedFile{}, Fields: make(map[string][]string)}
+	var body io.Reader = bytes.NewReader(nil)
+	if req.Body != nil {
+		body = req.Body
+	}
+	mediaType, params, err := mime.ParseMediaType(req.Headers["Content-Type"])
+	switch {
+	case err != nil:
+		err = &ProtocolError{StatusCode: 415, Reason: "missing or malformed Content-Type"}
+	case mediaType == "application/x-www-form-urlencoded":
+		err = readURLEncodedForm(body, result)
+	case mediaType == "multipart/form-data" && params["boundary"] != "":
+		err = upload.readMultipartForm(body, params["boundary"], dir, result)
+	default:
+		err = &ProtocolError{StatusCode: 415, Reason: "unsupported form type " + mediaType}
+	}
+	if err != nil {
+		return fs.handleUploadError(req, err)
+	}
+	return fs.handleUploadResult(req, result)
+}
+
+// readURLEncodedForm adds the fields of an urlencoded form to result.
+func readURLEncodedForm(body io.Reader, result *uploadResult) error {
+	data, err := io.ReadAll(io.LimitReader(body, maxFormBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxFormBytes {
+		return &ProtocolError{StatusCode: 413, Reason: "form fields too large"}
+	}
+	fields, err := url.ParseQuery(string(data))
+	if err != nil {
+		return badRequest("malformed form")
+	}
+	for name, values := range fields {
+		result.Fields[name] = append(result.Fields[name], values...)
+	}
+	return nil
+}
+
+// readMultipartForm reads a multipart form part by part, storing its
+// files in dir and adding them and its other fields to result. If it
+// fails, the files already stored are removed.
+func (o *UploadOptions) readMultipartForm(body io.Reader, boundary, dir string, result *uploadResult) (err error) {
+	var stored []string
+	defer func() {
+		if err != nil {
+			for _, p := range stored {
+				os.Remove(p)
+			}
+		}
+	}()
+
+	mr := multipart.NewReader(body, boundary)
+	fieldBytes := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return formError(err)
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, int64(maxFormBytes-fieldBytes+1)))
+			if err != nil {
+				return formError(err)
+			}
+			fieldBytes += len(value)
+			if fieldBytes > maxFormBytes {
+				return &ProtocolError{StatusCode: 413, Reason: "form fields too large"}
+			}
+			result.Fields[part.FormName()] = append(result.Fields[part.FormName()], string(value))
+			continue
+		}
+
+		contentType := "application/octet-stream"
+		if mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil {
+			contentType = mediaType
+		}
+		if !o.allowsType(contentType) {
+			return &ProtocolError{StatusCode: 415, Reason: "file type " + contentType + " not allowed"}
+		}
+		filePath, size, err := storeUpload(part, dir, safeFileName(part.FileName()), o.MaxFileBytes)
+		if err != nil {
+			return err
+		}
+		stored = append(stored, filePath)
+		result.Files = append(result.Files, uploadedFile{
+			Field:       part.FormName(),
+			Name:        part.FileName(),
+			URL:         (&url.URL{Path: path.Join("/", o.Dir, filepath.Base(filePath))}).EscapedPath(),
+			Size:        size,
+			ContentType: contentType,
+		})
+	}
+}
+
+// formError turns an error reading a form into the error to respond
+// with: a body that is too large or cannot be read is kept as it is,
+// and anything else means the form is malformed.
+func formError(err error) error {
+	if errors.Is(err, ErrBodyTooLarge) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	return badRequest("malformed multipart form: " + err.Error())
+}
+
+// storeUpload streams r into a new file in dir named name, or, if that
+// is taken, name with a number added; existing files are never
+// replaced. Like PUT, it writes to a temporary file first, so the file
+// only appears once complete.
+func storeUpload(r io.Reader, dir, name string, maxBytes int64) (filePath string, size int64, err error) {
+	tmp, err := os.CreateTemp(dir, ".tritonhttp-upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+	}
+	readErr, writeErr := copyBody(tmp, r)
+	if err := tmp.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if readErr != nil {
+		return "", 0, formError(readErr)
+	}
+	if writeErr != nil {
+		return "", 0, writeErr
+	}
+	fi, err := os.Stat(tmp.Name())
+	if err != nil {
+		return "", 0, err
+	}
+	if maxBytes > 0 && fi.Size() > maxBytes {
+		return "", 0, &ProtocolError{StatusCode: 413, Reason: "file " + name + " too large"}
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", 0, err
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = base + "-" + strconv.Itoa(i) + ext
+		}
+		filePath = filepath.Join(dir, candidate)
+		err := os.Link(tmp.Name(), filePath)
+		if err == nil {
+			return filePath, fi.Size(), nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", 0, err
+		}
+	}
+	return "", 0, fmt.Errorf("no free file name for %s in %s", name, dir)
+}
+
+// safeFileName reduces a file name sent by a client to a plain name
+// that stays in the upload directory and is not hidden.
+func safeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimLeft(name, ".")
+	if name == "" || name == "/" {
+		return "upload"
+	}
+	return name
+}
+
+// handleUploadError picks the error response for a failed upload:
+// the status of a *ProtocolError, 413 or 400 for a body that could not
+// be read, and 500 for anything unexpected, such as a full disk.
+func (fs *FileServer) handleUploadError(req *Request, err error) (res *Response) {
+	var perr *ProtocolError
+	switch {
+	case errors.As(err, &perr):
+		res = fs.handleErrorRequests(req, perr.StatusCode)
+	case errors.Is(err, ErrBodyTooLarge), errors.Is(err, io.ErrUnexpectedEOF):
+		return fs.handleBodyError(req, err)
+	default:
+		log.Println("upload error: ", err)
+		res = fs.handleErrorRequests(req, 500)
+	}
+	// the rest of the form is not worth reading
+	res.Headers["Connection"] = "close"
+	return res
+}
+
+// handleUploadResult reports an upload as JSON: 201 Created if files
+// were stored, or 200 if the form had only fields.
+func (fs *FileServer) handleUploadResult(req *Request, result *uploadResult) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 200
+	if len(result.Files) > 0 {
+		res.StatusCode = 201
+	}
+	res.StatusText = StatusText(res.StatusCode)
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	body, _ := json.Marshal(result)
+	body = append(body, '\n')
+	res.Headers["Content-Type"] = "application/json"
+	res.Headers["Content-Length"] = strconv.Itoa(len(body))
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.Body = bytes.NewReader(body)
+	return res
+}
@@ -0,0 +1,155 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// formPart is one part of a multipart form built by multipartForm; a
+// part with a fileName is a file.
+type formPart struct {
+	name, fileName, contentType, content string
+}
+
+func multipartForm(t *testing.T, parts []formPart) (contentType, body string) {
+	t.Helper()
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for _, p := range parts {
+		header := make(textproto.MIMEHeader)
+		disposition := `form-data; name="` + p.name + `"`
+		if p.fileName != "" {
+			disposition += `; filename="` + p.fileName + `"`
+		}
+		header.Set("Content-Disposition", disposition)
+		if p.contentType != "" {
+			header.Set("Content-Type", p.contentType)
+		}
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(p.content))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return mw.FormDataContentType(), b.String()
+}
+
+func TestUpload(t *testing.T) {
+	docRoot := t.TempDir()
+	uploadDir := filepath.Join(docRoot, "files")
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: &FileServer{
+		VirtualHosts: map[string]string{"test": docRoot},
+		HostOptions: map[string]HostOptions{
+			"test": {Upload: &UploadOptions{
+				Path:         "/upload",
+				Dir:          "files",
+				MaxFileBytes: 10,
+				AllowedTypes: []string{"text/plain", "image/*"},
+			}},
+		},
+	}}
+	post := func(url, contentType, body string) string {
+		return "POST " + url + " HTTP/1.1\r\nHost: test\r\nConnection: close\r\n" +
+			"Content-Type: " + contentType + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+
+	t.Run("multipart", func(t *testing.T) {
+		contentType, body := multipartForm(t, []formPart{
+			{name: "title", content: "holiday"},
+			{name: "file", fileName: "note.txt", contentType: "text/plain", content: "hello"},
+			{name: "file", fileName: "note.txt", contentType: "text/plain", content: "again"},
+			{name: "photo", fileName: `..\..\evil.png`, contentType: "image/png", content: "png"},
+		})
+		resp, respBody := serveOne(t, s, post("/upload", contentType, body))
+		if resp.StatusCode != 201 {
+			t.Fatalf("status code got: %v, want: 201 (%s)", resp.StatusCode, respBody)
+		}
+		var result uploadResult
+		if err := json.Unmarshal([]byte(respBody), &result); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.Fields, map[string][]string{"title": {"holiday"}}) {
+			t.Fatalf("fields got: %v", result.Fields)
+		}
+		var urls []string
+		for _, f := range result.Files {
+			urls = append(urls, f.URL)
+		}
+		urlsWant := []string{"/files/note.txt", "/files/note-1.txt", "/files/evil.png"}
+		if !reflect.DeepEqual(urls, urlsWant) {
+			t.Fatalf("urls got: %q, want: %q", urls, urlsWant)
+		}
+		for name, want := range map[string]string{"note.txt": "hello", "note-1.txt": "again", "evil.png": "png"} {
+			content, err := os.ReadFile(filepath.Join(uploadDir, name))
+			if err != nil || string(content) != want {
+				t.Fatalf("%s got: %q (%v), want: %q", name, content, err, want)
+			}
+		}
+	})
+
+	t.Run("urlencoded", func(t *testing.T) {
+		resp, respBody := serveOne(t, s, post("/upload", "application/x-www-form-urlencoded", "a=1&a=2&b=x+y"))
+		if resp.StatusCode != 200 {
+			t.Fatalf("status code got: %v, want: 200", resp.StatusCode)
+		}
+		var result uploadResult
+		if err := json.Unmarshal([]byte(respBody), &result); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.Fields, map[string][]string{"a": {"1", "2"}, "b": {"x y"}}) {
+			t.Fatalf("fields got: %v", result.Fields)
+		}
+	})
+
+	var tests = []struct {
+		name       string
+		url        string
+		parts      []formPart
+		statusWant int
+	}{
+		{"type not allowed", "/upload", []formPart{
+			{name: "file", fileName: "kept.txt", contentType: "text/plain", content: "first"},
+			{name: "file", fileName: "app.exe", contentType: "application/octet-stream", content: "MZ"},
+		}, 415},
+		{"file too large", "/upload", []formPart{
+			{name: "file", fileName: "kept.txt", contentType: "text/plain", content: "first"},
+			{name: "file", fileName: "big.txt", contentType: "text/plain", content: "0123456789a"},
+		}, 413},
+		{"not the upload path", "/other", []formPart{
+			{name: "file", fileName: "kept.txt", contentType: "text/plain", content: "first"},
+		}, 405},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, body := multipartForm(t, tt.parts)
+			resp, _ := serveOne(t, s, post(tt.url, contentType, body))
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
+			}
+			// a refused form keeps none of its files
+			if _, err := os.Stat(filepath.Join(uploadDir, "kept.txt")); err == nil {
+				t.Fatal("kept.txt was stored")
+			}
+		})
+	}
+
+	t.Run("not a form", func(t *testing.T) {
+		resp, _ := serveOne(t, s, post("/upload", "application/json", "{}"))
+		if resp.StatusCode != 415 {
+			t.Fatalf("status code got: %v, want: 415", resp.StatusCode)
+		}
+	})
+}
@@ -35,3 +35,23 @@ func FormatTime(t time.Time) string {
 func MIMETypeByExtension(ext string) string {
 	return mime.TypeByExtension(ext)
 }
+
+// timeFormats lists the HTTP-date formats a recipient must accept:
+// IMF-fixdate, the obsolete RFC 850 format and ANSI C's asctime().
+var timeFormats = []string{
+	"Mon, 02 Jan 2006 15:04:05 GMT",
+	time.RFC850,
+	time.ANSIC,
+}
+
+// ParseTime parses an HTTP-date such as the value of an
+// "If-Modified-Since" header. It is the inverse of FormatTime.
+func ParseTime(s string) (t time.Time, err error) {
+	for _, layout := range timeFormats {
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
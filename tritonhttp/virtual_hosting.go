@@ -1,42 +1,35 @@
 package tritonhttp
 
-import (
-	"io/ioutil"
-	"log"
-	"os"
-	"path/filepath"
-
-	"gopkg.in/yaml.v2"
-)
-
-type VHConfigs struct {
-	VirtualHosts []struct {
-		HostName string `yaml:"hostName"`
-		DocRoot  string `yaml:"docRoot"`
-	} `yaml:"virtual_hosts"`
-}
+import "log"
 
+// ParseVHConfigFile is like LoadVirtualHosts, but exits the program if
+// the config is invalid. New code should use LoadConfig.
 func ParseVHConfigFile(vhConfigFilePath string, docroot_dirs_path string) map[string]string {
-	vh_map := make(map[string]string)
-	f, err := ioutil.ReadFile(vhConfigFilePath)
-
+	vh_map, err := LoadVirtualHosts(vhConfigFilePath, docroot_dirs_path)
 	if err != nil {
-		log.Fatalf("could not read config file %s : %v", vhConfigFilePath, err)
+		log.Fatal(err)
 	}
+	return vh_map
+}
 
-	vhostConfigs := VHConfigs{}
-	err = yaml.Unmarshal(f, &vhostConfigs)
-
-	for _, vhost := range vhostConfigs.VirtualHosts {
-		docroot_path := filepath.Join(docroot_dirs_path, vhost.DocRoot)
-
-		// Check if the path exists
-		_, err := os.Stat(docroot_path)
-		if err != nil {
-			log.Fatalf("path to docroot %s doesn't exist : %v", docroot_path, err)
-		}
-		vh_map[vhost.HostName] = docroot_path
+// LoadVirtualHosts loads the config file with LoadConfig and returns
+// the mapping from host name to docRoot path.
+func LoadVirtualHosts(vhConfigFilePath string, docroot_dirs_path string) (map[string]string, error) {
+	config, err := LoadConfig(vhConfigFilePath, docroot_dirs_path)
+	if err != nil {
+		return nil, err
 	}
+	return config.VirtualHostMap(), nil
+}
 
-	return vh_map
+// ParseVHOptionsFile loads the config file with LoadConfig and returns
+// the per-host settings, such as index files and autoindex. The result
+// maps each host name to its HostOptions and is meant for
+// FileServer.HostOptions.
+func ParseVHOptionsFile(vhConfigFilePath string, docroot_dirs_path string) (map[string]HostOptions, error) {
+	config, err := LoadConfig(vhConfigFilePath, docroot_dirs_path)
+	if err != nil {
+		return nil, err
+	}
+	return config.HostOptionMap(), nil
 }
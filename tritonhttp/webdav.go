@@ -0,0 +1,408 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPropfindBytes bounds the XML body of a PROPFIND request.
+const maxPropfindBytes = 1 << 20
+
+// davNamespace is the XML namespace of the WebDAV properties.
+const davNamespace = "DAV:"
+
+// serveWebDAV handles OPTIONS, on every virtual host, and the WebDAV
+// methods PROPFIND, MKCOL, COPY and MOVE on virtual hosts with webdav
+// enabled. Methods that change the docRoot are only allowed where PUT
+// and DELETE are.
+func (fs *FileServer) serveWebDAV(req *Request) *Response {
+	_, _, absolutePath, ok := fs.resolve(req)
+	if !ok {
+		return fs.handle404Requests(req)
+	}
+	if !fs.methodAllowed(req) {
+		if _, err := os.Stat(absolutePath); err != nil && req.Method != "MKCOL" {
+			return fs.handleStatError(req, err)
+		}
+		return fs.handle405Requests(req)
+	}
+
+	switch req.Method {
+	case "OPTIONS":
+		return fs.handleOptions(req)
+	case "PROPFIND":
+		return fs.handlePropfind(req, absolutePath)
+	case "MKCOL":
+		return fs.handleMkcol(req, absolutePath)
+	default:
+		return fs.handleCopyMove(req, absolutePath)
+	}
+}
+
+func (fs *FileServer) handleOptions(req *Request) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 200
+	res.StatusText = "OK"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	res.Headers["Allow"] = fs.allowedMethods(req)
+	if fs.HostOptions[fs.virtualHost(req)].WebDAV {
+		res.Headers["DAV"] = "1"
+	}
+	res.Headers["Content-Length"] = "0"
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.FilePath = ""
+	return res
+}
+
+// propfind is the body of a PROPFIND request. An empty body asks for
+// all properties, like allprop.
+type propfind struct {
+	Allprop  *struct{} `xml:"DAV: allprop"`
+	Propname *struct{} `xml:"DAV: propname"`
+	Prop     struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// multistatus is the body of a 207 Multi-Status response.
+type multistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XMLNS     string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string     `xml:"D:href"`
+	Propstat []propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop struct {
+		Props []davProperty
+	} `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+func newPropstat(props []davProperty, status int) propstat {
+	ps := propstat{Status: "HTTP/1.1 " + strconv.Itoa(status) + " " + StatusText(status)}
+	for _, p := range props {
+		// write properties in the DAV: namespace with the prefix
+		// declared on the multistatus element
+		if p.XMLName.Space == davNamespace {
+			p.XMLName = xml.Name{Local: "D:" + p.XMLName.Local}
+		}
+		ps.Prop.Props = append(ps.Prop.Props, p)
+	}
+	return ps
+}
+
+// davProperty is a single property; InnerXML holds its value, already
+// escaped.
+type davProperty struct {
+	XMLName  xml.Name
+	InnerXML string `xml:",innerxml"`
+}
+
+// handlePropfind reports the properties of the target and, as the
+// Depth header asks, of its members, as a 207 Multi-Status.
+func (fs *FileServer) handlePropfind(req *Request, absolutePath string) *Response {
+	depth := -1
+	switch strings.ToLower(req.Headers["Depth"]) {
+	case "0":
+		depth = 0
+	case "1":
+		depth = 1
+	case "", "infinity":
+	default:
+		return fs.handleErrorRequests(req, 400)
+	}
+
+	var pf propfind
+	if req.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxPropfindBytes+1))
+		if err != nil {
+			return fs.handleBodyError(req, err)
+		}
+		if len(data) > maxPropfindBytes {
+			return fs.handleErrorRequests(req, 413)
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := xml.Unmarshal(data, &pf); err != nil {
+				return fs.handleErrorRequests(req, 400)
+			}
+		}
+	}
+
+	urlPath, _ := req.target()
+	urlPath = path.Clean("/" + urlPath)
+	ms := &multistatus{XMLNS: davNamespace}
+	err := filepath.WalkDir(absolutePath, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(absolutePath, p)
+		level := 0
+		if rel != "." {
+			level = strings.Count(rel, string(filepath.Separator)) + 1
+		}
+		if depth >= 0 && level > depth {
+			return filepath.SkipDir
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		href := path.Join(urlPath, filepath.ToSlash(rel))
+		ms.Responses = append(ms.Responses, propfindResponse(href, fi, &pf))
+		if d.IsDir() && depth >= 0 && level == depth {
+			// list the directory itself, but not its members
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return fs.handleStatError(req, err)
+	}
+
+	body, _ := xml.Marshal(ms)
+	body = append([]byte(xml.Header), body...)
+	res := &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 207
+	res.StatusText = "Multi-Status"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	res.Headers["Content-Type"] = "application/xml; charset=utf-8"
+	res.Headers["Content-Length"] = strconv.Itoa(len(body))
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.Body = bytes.NewReader(body)
+	return res
+}
+
+// propfindResponse describes the resource at href for a PROPFIND:
+// every property it has, just their names, or the requested ones, with
+// those it lacks listed as not found.
+func propfindResponse(href string, fi os.FileInfo, pf *propfind) davResponse {
+	props := davProperties(fi)
+	if fi.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	res := davResponse{Href: (&url.URL{Path: href}).EscapedPath()}
+
+	if len(pf.Prop.Names) == 0 || pf.Allprop != nil || pf.Propname != nil {
+		if pf.Propname != nil {
+			for i := range props {
+				props[i].InnerXML = ""
+			}
+		}
+		res.Propstat = []propstat{newPropstat(props, 200)}
+		return res
+	}
+
+	var found, missing []davProperty
+	for _, name := range pf.Prop.Names {
+		if prop, ok := findProperty(props, name.XMLName); ok {
+			found = append(found, prop)
+		} else {
+			missing = append(missing, davProperty{XMLName: name.XMLName})
+		}
+	}
+	if len(found) > 0 {
+		res.Propstat = append(res.Propstat, newPropstat(found, 200))
+	}
+	if len(missing) > 0 {
+		res.Propstat = append(res.Propstat, newPropstat(missing, 404))
+	}
+	return res
+}
+
+// davProperties returns the live properties of a file or directory.
+func davProperties(fi os.FileInfo) []davProperty {
+	prop := func(name, value string) davProperty {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(value))
+		return davProperty{XMLName: xml.Name{Space: davNamespace, Local: name}, InnerXML: b.String()}
+	}
+	props := []davProperty{
+		prop("displayname", fi.Name()),
+		prop("getlastmodified", FormatTime(fi.ModTime())),
+	}
+	if fi.IsDir() {
+		props = append(props, davProperty{XMLName: xml.Name{Space: davNamespace, Local: "resourcetype"}, InnerXML: "<D:collection/>"})
+		return props
+	}
+	return append(props,
+		davProperty{XMLName: xml.Name{Space: davNamespace, Local: "resourcetype"}},
+		prop("getcontentlength", strconv.FormatInt(fi.Size(), 10)),
+		prop("getcontenttype", MIMETypeByExtension(filepath.Ext(fi.Name()))),
+		prop("getetag", generateETag(fi)),
+	)
+}
+
+// findProperty returns the property of props called name.
+func findProperty(props []davProperty, name xml.Name) (davProperty, bool) {
+	for _, p := range props {
+		if p.XMLName == name {
+			return p, true
+		}
+	}
+	return davProperty{}, false
+}
+
+// handleMkcol creates the directory absolutePath. Its parent must
+// exist, and a MKCOL request may not have a body.
+func (fs *FileServer) handleMkcol(req *Request, absolutePath string) *Response {
+	if req.Body != nil {
+		return fs.handleErrorRequests(req, 415)
+	}
+	if err := os.Mkdir(absolutePath, 0755); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fs.handleErrorRequests(req, 409)
+		}
+		return fs.handleStatError(req, err)
+	}
+	return fs.handle201Requests(req, "")
+}
+
+// handleCopyMove copies or moves the file or directory at absolutePath
+// to the Destination of req, which must be on the same virtual host and
+// writable. An existing destination is replaced unless the request
+// says "Overwrite: F".
+func (fs *FileServer) handleCopyMove(req *Request, absolutePath string) *Response {
+	hostName := fs.virtualHost(req)
+	dest, err := url.Parse(req.Headers["Destination"])
+	if err != nil || dest.Path == "" {
+		return fs.handleErrorRequests(req, 400)
+	}
+	// the destination path is decoded as safely as request paths
+	if dest.Path, err = decodePath(dest.EscapedPath(), false); err != nil {
+		return fs.handleErrorRequests(req, 400)
+	}
+	if dest.Host != "" {
+		if fs.Lookup(dest.Host) != hostName {
+			return fs.handleErrorRequests(req, 502)
+		}
+	}
+	destURLPath := path.Clean("/" + dest.Path)
+	if !fs.HostOptions[hostName].canWrite(destURLPath) || destURLPath == "/" {
+		return fs.handleErrorRequests(req, 403)
+	}
+	destPath := filepath.Join(fs.VirtualHosts[hostName], filepath.FromSlash(destURLPath))
+
+	// a directory cannot be copied or moved into itself, and replacing
+	// a directory the source is in would remove the source
+	if destPath == absolutePath || strings.HasPrefix(destPath, absolutePath+string(filepath.Separator)) ||
+		strings.HasPrefix(absolutePath, destPath+string(filepath.Separator)) {
+		return fs.handleErrorRequests(req, 403)
+	}
+	depthZero := false
+	switch strings.ToLower(req.Headers["Depth"]) {
+	case "", "infinity":
+	case "0":
+		depthZero = req.Method == "COPY"
+		if !depthZero {
+			return fs.handleErrorRequests(req, 400)
+		}
+	default:
+		return fs.handleErrorRequests(req, 400)
+	}
+
+	if fi, err := os.Stat(filepath.Dir(destPath)); err != nil || !fi.IsDir() {
+		return fs.handleErrorRequests(req, 409)
+	}
+	existed := false
+	if _, err := os.Lstat(destPath); err == nil {
+		if strings.EqualFold(req.Headers["Overwrite"], "F") {
+			return fs.handleErrorRequests(req, 412)
+		}
+		existed = true
+		if err := os.RemoveAll(destPath); err != nil {
+			return fs.handleStatError(req, err)
+		}
+	}
+
+	if req.Method == "MOVE" {
+		err = os.Rename(absolutePath, destPath)
+	} else {
+		err = copyTree(absolutePath, destPath, depthZero)
+	}
+	if err != nil {
+		log.Println(strings.ToLower(req.Method)+" error: ", err)
+		return fs.handleStatError(req, err)
+	}
+	if existed {
+		return fs.handle204Requests(req, "")
+	}
+	res := fs.handle201Requests(req, "")
+	res.Headers["Location"] = (&url.URL{Path: destURLPath}).EscapedPath()
+	return res
+}
+
+// copyTree copies the file or directory src to dst, which must not
+// exist. If depthZero is set, a directory is copied without its
+// members.
+func copyTree(src, dst string, depthZero bool) error {
+	return filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := filepath.Join(dst, rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if err := os.Mkdir(target, fi.Mode().Perm()); err != nil {
+				return err
+			}
+			if depthZero {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			// symlinks and other special files are left out
+			return nil
+		}
+		return copyFile(p, target, fi.Mode().Perm())
+	})
+}
+
+// copyFile copies the regular file src to the new file dst.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,193 @@
+package tritonhttp
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsedMultistatus is a 207 response body, read back for checking.
+type parsedMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				Props []struct {
+					XMLName xml.Name
+					Value   string `xml:",innerxml"`
+				} `xml:",any"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func TestWebDAV(t *testing.T) {
+	docRoot := t.TempDir()
+	for _, dir := range []string{"dav/sub/deep", "static"} {
+		if err := os.MkdirAll(filepath.Join(docRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		"dav/a.txt":              "aaa",
+		"dav/sub/b.txt":          "bb",
+		"dav/sub/deep/c.txt":     "c",
+		"static/read-only.txt":   "ro",
+		"static/also-static.txt": "ro",
+	} {
+		if err := os.WriteFile(filepath.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{Handler: &FileServer{
+		VirtualHosts: map[string]string{"test": docRoot, "plain": docRoot},
+		HostOptions: map[string]HostOptions{
+			"test": {WebDAV: true, Writable: true, WritablePaths: []string{"/dav"}},
+		},
+	}}
+	request := func(method, url, headers, body string) string {
+		req := method + " " + url + " HTTP/1.1\r\nHost: test\r\nConnection: close\r\n" + headers
+		if body != "" {
+			req += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+		}
+		return req + "\r\n" + body
+	}
+
+	t.Run("options", func(t *testing.T) {
+		resp, _ := serveOne(t, s, request("OPTIONS", "/dav/a.txt", "", ""))
+		if resp.StatusCode != 200 || resp.Header.Get("DAV") != "1" {
+			t.Fatalf("got: %v with DAV %q, want: 200 with DAV 1", resp.StatusCode, resp.Header.Get("DAV"))
+		}
+		allowWant := "GET, HEAD, PUT, DELETE, OPTIONS, PROPFIND, COPY, MOVE"
+		if got := resp.Header.Get("Allow"); got != allowWant {
+			t.Fatalf("Allow got: %q, want: %q", got, allowWant)
+		}
+	})
+
+	t.Run("options without webdav", func(t *testing.T) {
+		resp, _ := serveOne(t, s, strings.Replace(request("OPTIONS", "/dav/a.txt", "", ""), "Host: test", "Host: plain", 1))
+		if resp.StatusCode != 200 || resp.Header.Get("DAV") != "" {
+			t.Fatalf("got: %v with DAV %q, want: 200 without DAV", resp.StatusCode, resp.Header.Get("DAV"))
+		}
+		if got := resp.Header.Get("Allow"); got != "GET, HEAD, OPTIONS" {
+			t.Fatalf("Allow got: %q, want: %q", got, "GET, HEAD, OPTIONS")
+		}
+	})
+
+	t.Run("propfind without webdav", func(t *testing.T) {
+		resp, _ := serveOne(t, s, strings.Replace(request("PROPFIND", "/dav/a.txt", "", ""), "Host: test", "Host: plain", 1))
+		if resp.StatusCode != 405 {
+			t.Fatalf("status code got: %v, want: 405", resp.StatusCode)
+		}
+	})
+
+	var propfindTests = []struct {
+		name      string
+		depth     string
+		hrefsWant []string
+	}{
+		{"depth 0", "0", []string{"/dav/"}},
+		{"depth 1", "1", []string{"/dav/", "/dav/a.txt", "/dav/sub/"}},
+		{"depth infinity", "infinity", []string{"/dav/", "/dav/a.txt", "/dav/sub/", "/dav/sub/b.txt", "/dav/sub/deep/", "/dav/sub/deep/c.txt"}},
+	}
+	for _, tt := range propfindTests {
+		t.Run("propfind "+tt.name, func(t *testing.T) {
+			resp, body := serveOne(t, s, request("PROPFIND", "/dav", "Depth: "+tt.depth+"\r\n", ""))
+			if resp.StatusCode != 207 {
+				t.Fatalf("status code got: %v, want: 207", resp.StatusCode)
+			}
+			var ms parsedMultistatus
+			if err := xml.Unmarshal([]byte(body), &ms); err != nil {
+				t.Fatalf("%v:\n%s", err, body)
+			}
+			var hrefs []string
+			for _, r := range ms.Responses {
+				hrefs = append(hrefs, r.Href)
+			}
+			if !reflect.DeepEqual(hrefs, tt.hrefsWant) {
+				t.Fatalf("hrefs got: %q, want: %q", hrefs, tt.hrefsWant)
+			}
+		})
+	}
+
+	t.Run("propfind named properties", func(t *testing.T) {
+		body := `<?xml version="1.0"?><propfind xmlns="DAV:"><prop><getcontentlength/><resourcetype/><x:color xmlns:x="urn:x"/></prop></propfind>`
+		resp, respBody := serveOne(t, s, request("PROPFIND", "/dav/a.txt", "Depth: 0\r\n", body))
+		if resp.StatusCode != 207 {
+			t.Fatalf("status code got: %v, want: 207", resp.StatusCode)
+		}
+		var ms parsedMultistatus
+		if err := xml.Unmarshal([]byte(respBody), &ms); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, ps := range ms.Responses[0].Propstat {
+			for _, p := range ps.Prop.Props {
+				got[p.XMLName.Local] = ps.Status + " " + p.Value
+			}
+		}
+		want := map[string]string{
+			"getcontentlength": "HTTP/1.1 200 OK 3",
+			"resourcetype":     "HTTP/1.1 200 OK ",
+			"color":            "HTTP/1.1 404 Not Found ",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("properties got: %q, want: %q", got, want)
+		}
+	})
+
+	var tests = []struct {
+		name       string
+		req        string
+		statusWant int
+		exists     []string
+		missing    []string
+	}{
+		{"mkcol", request("MKCOL", "/dav/new", "", ""), 201, []string{"dav/new"}, nil},
+		{"mkcol existing", request("MKCOL", "/dav/new", "", ""), 405, nil, nil},
+		{"mkcol without parent", request("MKCOL", "/dav/x/y", "", ""), 409, nil, []string{"dav/x"}},
+		{"mkcol read-only", request("MKCOL", "/static/new", "", ""), 405, nil, []string{"static/new"}},
+		{"copy tree", request("COPY", "/dav/sub", "Destination: http://test/dav/copy\r\n", ""), 201, []string{"dav/copy/deep/c.txt", "dav/sub/b.txt"}, nil},
+		{"copy depth 0", request("COPY", "/dav/sub", "Destination: /dav/shallow\r\nDepth: 0\r\n", ""), 201, []string{"dav/shallow"}, []string{"dav/shallow/b.txt"}},
+		{"copy from read-only", request("COPY", "/static/read-only.txt", "Destination: /dav/ro.txt\r\n", ""), 201, []string{"dav/ro.txt"}, nil},
+		{"copy to read-only", request("COPY", "/dav/a.txt", "Destination: /static/a.txt\r\n", ""), 403, nil, []string{"static/a.txt"}},
+		{"copy into itself", request("COPY", "/dav/sub", "Destination: /dav/sub/inner\r\n", ""), 403, nil, []string{"dav/sub/inner"}},
+		{"move onto parent", request("MOVE", "/dav/sub/b.txt", "Destination: /dav/sub\r\n", ""), 403, []string{"dav/sub/b.txt"}, nil},
+		{"copy onto ancestor", request("COPY", "/dav/sub/deep/c.txt", "Destination: http://test/dav\r\n", ""), 403, []string{"dav/sub/deep/c.txt"}, nil},
+		{"uppercase scheme", request("COPY", "/dav/a.txt", "Destination: HTTP://test/dav/upper.txt\r\n", ""), 201, []string{"dav/upper.txt"}, nil},
+		{"copy to other host", request("COPY", "/dav/a.txt", "Destination: http://elsewhere/dav/z.txt\r\n", ""), 502, nil, []string{"dav/z.txt"}},
+		{"no overwrite", request("COPY", "/dav/a.txt", "Destination: /dav/ro.txt\r\nOverwrite: F\r\n", ""), 412, nil, nil},
+		{"overwrite", request("COPY", "/dav/a.txt", "Destination: /dav/ro.txt\r\n", ""), 204, []string{"dav/ro.txt"}, nil},
+		{"move", request("MOVE", "/dav/copy", "Destination: /dav/moved\r\n", ""), 201, []string{"dav/moved/deep/c.txt"}, []string{"dav/copy"}},
+		{"move read-only", request("MOVE", "/static/read-only.txt", "Destination: /dav/stolen.txt\r\n", ""), 405, []string{"static/read-only.txt"}, nil},
+		{"move missing", request("MOVE", "/dav/missing", "Destination: /dav/found\r\n", ""), 404, nil, nil},
+		{"delete collection", request("DELETE", "/dav/moved", "", ""), 204, nil, []string{"dav/moved"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := serveOne(t, s, tt.req)
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
+			}
+			for _, name := range tt.exists {
+				if _, err := os.Stat(filepath.Join(docRoot, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range tt.missing {
+				if _, err := os.Stat(filepath.Join(docRoot, name)); err == nil {
+					t.Fatalf("%s exists, want: none", name)
+				}
+			}
+		})
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(docRoot, "dav/ro.txt")); string(content) != "aaa" {
+		t.Fatalf("overwritten file got: %q, want: %q", content, "aaa")
+	}
+}
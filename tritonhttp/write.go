@@ -0,0 +1,236 @@
+package tritonhttp
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// allowedMethods lists the methods allowed on the target of req, for
+// the Allow header of a 405. For the target "*", it lists the methods
+// the virtual host supports anywhere.
+func (fs *FileServer) allowedMethods(req *Request) string {
+	urlPath, _ := req.target()
+	options := fs.HostOptions[fs.virtualHost(req)]
+	if urlPath == "*" {
+		return options.hostMethods()
+	}
+	urlPath = path.Clean("/" + urlPath)
+	fi, err := os.Stat(fs.localPath(req))
+	exists := err == nil
+	isDir := exists && fi.IsDir()
+	writable := options.canWrite(urlPath)
+
+	methods := []string{"GET", "HEAD"}
+	if options.Upload != nil && urlPath == path.Clean("/"+options.Upload.Path) {
+		methods = append(methods, "POST")
+	}
+	// directories cannot be replaced, and only deleted through WebDAV;
+	// the docRoot never can
+	if writable && !isDir {
+		methods = append(methods, "PUT")
+	}
+	if writable && (!isDir || options.WebDAV) && urlPath != "/" {
+		methods = append(methods, "DELETE")
+	}
+	methods = append(methods, "OPTIONS")
+	if options.WebDAV {
+		methods = append(methods, "PROPFIND")
+		if exists {
+			methods = append(methods, "COPY")
+		}
+		if writable && exists && urlPath != "/" {
+			methods = append(methods, "MOVE")
+		}
+		if writable && !exists {
+			methods = append(methods, "MKCOL")
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// methodAllowed reports whether the method of req is allowed on its
+// target.
+func (fs *FileServer) methodAllowed(req *Request) bool {
+	for _, method := range strings.Split(fs.allowedMethods(req), ", ") {
+		if method == req.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// serveWrite handles PUT and DELETE, which only virtual hosts marked
+// writable allow, and only under their writable paths.
+func (fs *FileServer) serveWrite(req *Request) *Response {
+	_, _, absolutePath, ok := fs.resolve(req)
+	if !ok {
+		return fs.handle404Requests(req)
+	}
+	if !fs.methodAllowed(req) {
+		return fs.handle405Requests(req)
+	}
+
+	// the current ETag, or "" if there is no file yet
+	etag := ""
+	fi, err := os.Stat(absolutePath)
+	if err == nil {
+		etag = generateETag(fi)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fs.handleStatError(req, err)
+	}
+	if !writePreconditionsMet(req, etag) {
+		return fs.handleErrorRequests(req, 412)
+	}
+
+	if req.Method == "DELETE" {
+		if fi == nil {
+			return fs.handle404Requests(req)
+		}
+		if err := os.RemoveAll(absolutePath); err != nil {
+			return fs.handleStatError(req, err)
+		}
+		return fs.handle204Requests(req, "")
+	}
+	return fs.handlePut(req, absolutePath, fi)
+}
+
+// handlePut stores the request body as the file at absolutePath, whose
+// current FileInfo is fi, or nil if it does not exist yet. The body is
+// written to a temporary file next to it, which then replaces it in
+// one step, so readers see either the old or the new file in full.
+func (fs *FileServer) handlePut(req *Request, absolutePath string, fi os.FileInfo) *Response {
+	dir := filepath.Dir(absolutePath)
+	if dirFi, err := os.Stat(dir); err != nil || !dirFi.IsDir() {
+		// parent directories are not created implicitly
+		return fs.handleErrorRequests(req, 409)
+	}
+	tmp, err := os.CreateTemp(dir, ".tritonhttp-put-*")
+	if err != nil {
+		return fs.handleStatError(req, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	var readErr, writeErr error
+	if req.Body != nil {
+		readErr, writeErr = copyBody(tmp, req.Body)
+	}
+	if err := tmp.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if readErr != nil {
+		return fs.handleBodyError(req, readErr)
+	}
+	if writeErr != nil {
+		log.Println("write file error: ", writeErr)
+		return fs.handleErrorRequests(req, 500)
+	}
+
+	mode := os.FileMode(0644)
+	if fi != nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fs.handleStatError(req, err)
+	}
+
+	if fi == nil && req.Headers["If-None-Match"] == "*" {
+		// a create-only PUT must not replace a file created since the
+		// precondition was checked; a hard link fails if one exists
+		if err := os.Link(tmpPath, absolutePath); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return fs.handleErrorRequests(req, 412)
+			}
+			return fs.handleStatError(req, err)
+		}
+	} else if err := os.Rename(tmpPath, absolutePath); err != nil {
+		return fs.handleStatError(req, err)
+	}
+
+	etag := ""
+	if newFi, err := os.Stat(absolutePath); err == nil {
+		etag = generateETag(newFi)
+	}
+	if fi != nil {
+		return fs.handle204Requests(req, etag)
+	}
+	return fs.handle201Requests(req, etag)
+}
+
+// copyBody copies src to dst, telling errors reading the request body
+// apart from errors writing the file.
+func copyBody(dst io.Writer, src io.Reader) (readErr, writeErr error) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+}
+
+// handleBodyError picks the error response for a request body that
+// could not be read: 413 if it is too large, or else 400.
+func (fs *FileServer) handleBodyError(req *Request, err error) (res *Response) {
+	if errors.Is(err, ErrBodyTooLarge) {
+		res = fs.handleErrorRequests(req, 413)
+	} else {
+		res = fs.handleErrorRequests(req, 400)
+	}
+	// the rest of the body cannot be skipped reliably
+	res.Headers["Connection"] = "close"
+	return res
+}
+
+func (fs *FileServer) handle201Requests(req *Request, etag string) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 201
+	res.StatusText = "Created"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	urlPath, _, _ := strings.Cut(req.URL, "?")
+	res.Headers["Location"] = urlPath
+	if etag != "" {
+		res.Headers["ETag"] = etag
+	}
+	res.Headers["Content-Length"] = "0"
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.FilePath = ""
+	return res
+}
+
+func (fs *FileServer) handle204Requests(req *Request, etag string) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.StatusCode = 204
+	res.StatusText = "No Content"
+	res.Headers = make(map[string]string)
+	res.Headers["Date"] = FormatTime(time.Now())
+	if etag != "" {
+		res.Headers["ETag"] = etag
+	}
+	if req.Close {
+		res.Headers["Connection"] = "close"
+	}
+	res.Request = req
+	res.FilePath = ""
+	return res
+}
@@ -0,0 +1,103 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPutAndDelete(t *testing.T) {
+	docRoot := t.TempDir()
+	for _, dir := range []string{"uploads/dir", "static"} {
+		if err := os.MkdirAll(filepath.Join(docRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		"uploads/old.txt": "old",
+		"static/keep.txt": "keep",
+	} {
+		if err := os.WriteFile(filepath.Join(docRoot, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{MaxBodyBytes: 16, Handler: &FileServer{
+		VirtualHosts: map[string]string{"test": docRoot, "readonly": docRoot},
+		HostOptions: map[string]HostOptions{
+			"test": {Writable: true, WritablePaths: []string{"/uploads/"}},
+		},
+	}}
+	put := func(host, url, headers, body string) string {
+		return "PUT " + url + " HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n" + headers +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+	del := func(host, url, headers string) string {
+		return "DELETE " + url + " HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n" + headers + "\r\n"
+	}
+
+	var tests = []struct {
+		name        string
+		req         string
+		statusWant  int
+		allowWant   string
+		file        string
+		contentWant string // "" if file must not exist
+	}{
+		{"create", put("test", "/uploads/new.txt", "", "new"), 201, "", "uploads/new.txt", "new"},
+		{"replace", put("test", "/uploads/old.txt", "", "newer"), 204, "", "uploads/old.txt", "newer"},
+		{"create only, exists", put("test", "/uploads/old.txt", "If-None-Match: *\r\n", "nope"), 412, "", "uploads/old.txt", "newer"},
+		{"create only", put("test", "/uploads/once.txt", "If-None-Match: *\r\n", "once"), 201, "", "uploads/once.txt", "once"},
+		{"replace only, missing", put("test", "/uploads/none.txt", "If-Match: *\r\n", "nope"), 412, "", "uploads/none.txt", ""},
+		{"replace only", put("test", "/uploads/once.txt", "If-Match: *\r\n", "twice"), 204, "", "uploads/once.txt", "twice"},
+		{"missing parent", put("test", "/uploads/a/b.txt", "", "nope"), 409, "", "uploads/a/b.txt", ""},
+		{"too large", put("test", "/uploads/big.txt", "", "0123456789abcdefg"), 413, "", "uploads/big.txt", ""},
+		{"outside writable paths", put("test", "/static/keep.txt", "", "nope"), 405, "GET, HEAD, OPTIONS", "static/keep.txt", "keep"},
+		{"read-only host", put("readonly", "/uploads/old.txt", "", "nope"), 405, "GET, HEAD, OPTIONS", "uploads/old.txt", "newer"},
+		{"onto a directory", put("test", "/uploads/dir", "", "nope"), 405, "GET, HEAD, OPTIONS", "", ""},
+		{"escape docroot", put("test", "/uploads/../../escape.txt", "", "nope"), 405, "GET, HEAD, OPTIONS", "../escape.txt", ""},
+		{"delete", del("test", "/uploads/old.txt", ""), 204, "", "uploads/old.txt", ""},
+		{"delete missing", del("test", "/uploads/old.txt", ""), 404, "", "", ""},
+		{"delete outside writable paths", del("test", "/static/keep.txt", ""), 405, "GET, HEAD, OPTIONS", "static/keep.txt", "keep"},
+		{"delete with stale etag", del("test", "/uploads/new.txt", "If-Match: \"stale\"\r\n"), 412, "", "uploads/new.txt", "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := serveOne(t, s, tt.req)
+			if resp.StatusCode != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", resp.StatusCode, tt.statusWant)
+			}
+			if got := resp.Header.Get("Allow"); got != tt.allowWant {
+				t.Fatalf("Allow got: %q, want: %q", got, tt.allowWant)
+			}
+			if tt.file == "" {
+				return
+			}
+			content, err := os.ReadFile(filepath.Join(docRoot, tt.file))
+			if tt.contentWant == "" {
+				if err == nil {
+					t.Fatalf("%s exists, want: none", tt.file)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.contentWant {
+				t.Fatalf("%s got: %q, want: %q", tt.file, content, tt.contentWant)
+			}
+		})
+	}
+
+	// failed uploads leave no temporary files behind
+	entries, err := os.ReadDir(filepath.Join(docRoot, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".tritonhttp-put-") {
+			t.Fatalf("leftover file %s", entry.Name())
+		}
+	}
+}